@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// adminKeyScopes parses ADMIN_API_KEYS ("key=scope|scope,...") into the scopes of each key
+func adminKeyScopes() map[string][]string {
+	keys := make(map[string][]string)
+	for key, scopes := range parseKeyValueList(config.AdminAPIKeys) {
+		keys[key] = parseList(strings.ReplaceAll(scopes, "|", ","))
+	}
+	return keys
+}
+
+// AuthenticateAdminKey returns the scopes of an admin API key. Every configured key is compared in
+// constant time, so response times do not reveal how much of a key was right.
+func (e *RealtimeEngine) AuthenticateAdminKey(key string) ([]string, error) {
+	if key == "" {
+		return nil, fmt.Errorf("admin API key required")
+	}
+
+	keyHash := sha256.Sum256([]byte(key))
+	var scopes []string
+	matched := false
+	for configuredKey, configuredScopes := range adminKeyScopes() {
+		configuredHash := sha256.Sum256([]byte(configuredKey))
+		if subtle.ConstantTimeCompare(keyHash[:], configuredHash[:]) == 1 {
+			scopes = configuredScopes
+			matched = true
+		}
+	}
+	if !matched {
+		return nil, fmt.Errorf("invalid admin API key")
+	}
+	return scopes, nil
+}
+
+// logAdminKeys reports how many admin API keys are configured
+func logAdminKeys() {
+	keys := adminKeyScopes()
+	if len(keys) == 0 {
+		log.Println("⚠️  ADMIN_API_KEYS not set - the admin HTTP API rejects every request")
+		return
+	}
+	log.Printf("🔑 %d admin API key(s) configured", len(keys))
+}
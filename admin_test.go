@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/suisseworks/whagonsRLE/routes"
+)
+
+// withAdminKeys sets ADMIN_API_KEYS for one test
+func withAdminKeys(t *testing.T, keys string) {
+	t.Helper()
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.AdminAPIKeys = keys
+}
+
+func TestAuthenticateAdminKey(t *testing.T) {
+	withAdminKeys(t, "root-key=*,reader-key=read|sessions")
+	e := newTestEngine()
+
+	tests := []struct {
+		name    string
+		key     string
+		want    []string
+		wantErr bool
+	}{
+		{name: "no key", key: "", wantErr: true},
+		{name: "unknown key", key: "other-key", wantErr: true},
+		{name: "prefix of a key", key: "root", wantErr: true},
+		{name: "all scopes", key: "root-key", want: []string{"*"}},
+		{name: "listed scopes", key: "reader-key", want: []string{"read", "sessions"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scopes, err := e.AuthenticateAdminKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AuthenticateAdminKey(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			}
+			if !slices.Equal(scopes, tt.want) {
+				t.Errorf("AuthenticateAdminKey(%q) = %v, want %v", tt.key, scopes, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminRouteScopes(t *testing.T) {
+	output := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(output)
+
+	tests := []struct {
+		name   string
+		keys   string // ADMIN_API_KEYS
+		method string
+		path   string
+		header string
+		value  string
+		want   int
+	}{
+		{name: "health without keys configured", keys: "", method: "GET", path: "/api/health", want: fiber.StatusServiceUnavailable},
+		{name: "metrics without keys configured", keys: "", method: "GET", path: "/api/metrics", want: fiber.StatusUnauthorized},
+		{name: "no key", keys: "reader-key=read", method: "GET", path: "/api/sessions/count", want: fiber.StatusUnauthorized},
+		{name: "unknown key", keys: "reader-key=read", method: "GET", path: "/api/sessions/count", header: "X-API-Key", value: "other-key", want: fiber.StatusUnauthorized},
+		{name: "X-API-Key with the scope", keys: "reader-key=read", method: "GET", path: "/api/sessions/count", header: "X-API-Key", value: "reader-key", want: fiber.StatusOK},
+		{name: "Bearer with the scope", keys: "reader-key=read", method: "GET", path: "/api/sessions/count", header: "Authorization", value: "Bearer reader-key", want: fiber.StatusOK},
+		{name: "Bearer with an unknown key", keys: "reader-key=read", method: "GET", path: "/api/sessions/count", header: "Authorization", value: "Bearer other-key", want: fiber.StatusUnauthorized},
+		{name: "missing scope", keys: "reader-key=read", method: "POST", path: "/api/sessions/disconnect-all", header: "X-API-Key", value: "reader-key", want: fiber.StatusForbidden},
+		{name: "missing group scope", keys: "reader-key=read", method: "POST", path: "/api/tenants/reload", header: "Authorization", value: "Bearer reader-key", want: fiber.StatusForbidden},
+		{name: "all scopes", keys: "root-key=*", method: "GET", path: "/api/sessions/count", header: "X-API-Key", value: "root-key", want: fiber.StatusOK},
+		{name: "all scopes include sessions", keys: "root-key=*", method: "POST", path: "/api/sessions/disconnect-all", header: "X-API-Key", value: "root-key", want: fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAdminKeys(t, tt.keys)
+			app := fiber.New(fiber.Config{DisableStartupMessage: true})
+			routes.SetupRoutes(app, newTestEngine())
+
+			request := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				request.Header.Set(tt.header, tt.value)
+			}
+			response, err := app.Test(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, response.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
 	"strconv"
@@ -11,20 +12,12 @@ import (
 	"time"
 )
 
-// authenticateToken validates a Laravel Sanctum bearer token for a specific tenant domain
+// authenticateToken validates a bearer token (Sanctum, JWT, ...) for a specific tenant domain
 func (e *RealtimeEngine) authenticateTokenForDomain(bearerToken, domain string) (*AuthenticatedSession, error) {
 	// Check cache first
 	if cachedAuth := e.getCachedToken(bearerToken, domain); cachedAuth != nil {
 		log.Printf("✅ Using cached authentication for domain: %s", domain)
-		// Create a copy with new session ID (will be set by caller)
-		return &AuthenticatedSession{
-			TenantName: cachedAuth.TenantName,
-			UserID:     cachedAuth.UserID,
-			TokenID:    cachedAuth.TokenID,
-			Abilities:  cachedAuth.Abilities,
-			ExpiresAt:  cachedAuth.ExpiresAt,
-			LastUsedAt: time.Now(),
-		}, nil
+		return cachedAuth.forNewSession(), nil
 	}
 
 	// Cache miss - authenticate against database
@@ -33,14 +26,32 @@ func (e *RealtimeEngine) authenticateTokenForDomain(bearerToken, domain string)
 	if err != nil {
 		return nil, err
 	}
+	authSession.TokenKey = tokenKey(bearerToken)
 
-	// Cache the successful authentication
+	// Cache the successful authentication; the caller gets its own copy to fill in
 	e.cacheToken(bearerToken, domain, authSession)
 
-	return authSession, nil
+	return authSession.forNewSession(), nil
 }
 
-// authenticateTokenForDomainDB performs the actual database authentication (renamed from original)
+// forNewSession copies a cached authentication for a new session (its session id and domain are
+// set by the caller), so sessions sharing a token never write to the same value
+func (auth *AuthenticatedSession) forNewSession() *AuthenticatedSession {
+	session := *auth
+	session.SessionID = ""
+	session.Domain = ""
+	session.Abilities = append([]string(nil), auth.Abilities...)
+	session.LastUsedAt = time.Now()
+	return &session
+}
+
+// tokenKey identifies a bearer token without keeping it
+func tokenKey(bearerToken string) string {
+	hash := sha256.Sum256([]byte(bearerToken))
+	return hex.EncodeToString(hash[:])
+}
+
+// authenticateTokenForDomainDB resolves the tenant of a domain and authenticates the token with its authenticators
 func (e *RealtimeEngine) authenticateTokenForDomainDB(bearerToken, domain string) (*AuthenticatedSession, error) {
 	// First, look up the tenant information from the landlord database
 	tenantInfo, err := e.getTenantByDomain(domain)
@@ -59,6 +70,73 @@ func (e *RealtimeEngine) authenticateTokenForDomainDB(bearerToken, domain string
 		return nil, fmt.Errorf("database connection not found for tenant: %s", tenantInfo.Name)
 	}
 
+	// Try the tenant's authenticators in order, the first accepting the token wins
+	var failures []string
+	for _, authenticator := range e.authenticatorsForTenant(tenantInfo.Name) {
+		authSession, err := authenticator.Authenticate(*tenantInfo, tenantDB, bearerToken)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", authenticator.Name(), err))
+			continue
+		}
+		authSession.Authenticator = authenticator.Name()
+
+		log.Printf("✅ Token authenticated by %s for domain %s, tenant: %s, user: %d",
+			authenticator.Name(), domain, tenantInfo.Name, authSession.UserID)
+		return authSession, nil
+	}
+	if len(failures) == 0 {
+		return nil, fmt.Errorf("no authenticator configured for tenant %s", tenantInfo.Name)
+	}
+	return nil, fmt.Errorf("authentication failed for tenant %s: %s", tenantInfo.Name, strings.Join(failures, "; "))
+}
+
+// authenticatorNamesForTenant returns the authenticators configured for a tenant, in the order they are tried
+func authenticatorNamesForTenant(tenantName string) []string {
+	names := config.Authenticator
+	if tenantNames, exists := parseKeyValueList(config.AuthenticatorTenants)[tenantName]; exists {
+		names = tenantNames
+	}
+	return parseList(strings.ReplaceAll(names, "|", ","))
+}
+
+// authenticatorsForTenant returns the registered authenticators of a tenant, skipping unknown names
+func (e *RealtimeEngine) authenticatorsForTenant(tenantName string) []Authenticator {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	var authenticators []Authenticator
+	for _, name := range authenticatorNamesForTenant(tenantName) {
+		authenticator, exists := e.authenticators[name]
+		if !exists {
+			log.Printf("⚠️  Unknown authenticator '%s' configured for tenant %s", name, tenantName)
+			continue
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	return authenticators
+}
+
+// registerDefaultAuthenticators registers the built-in authenticators
+func (e *RealtimeEngine) registerDefaultAuthenticators() {
+	e.RegisterAuthenticator(&sanctumAuthenticator{})
+	e.RegisterAuthenticator(&jwtAuthenticator{})
+}
+
+// RegisterAuthenticator registers an authenticator under its name, replacing any existing one
+func (e *RealtimeEngine) RegisterAuthenticator(authenticator Authenticator) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.authenticators[authenticator.Name()] = authenticator
+}
+
+// sanctumAuthenticator validates Laravel Sanctum personal access tokens ({id}|{plain_text_token})
+// against the personal_access_tokens table of the tenant database
+type sanctumAuthenticator struct{}
+
+func (a *sanctumAuthenticator) Name() string { return "sanctum" }
+
+// Authenticate looks the token up by id and SHA-256 hash (implements Authenticator)
+func (a *sanctumAuthenticator) Authenticate(tenant TenantDB, db *sql.DB, bearerToken string) (*AuthenticatedSession, error) {
 	// Parse Laravel Sanctum token format: {token_id}|{plain_text_token}
 	tokenParts := strings.Split(bearerToken, "|")
 	if len(tokenParts) != 2 {
@@ -77,16 +155,10 @@ func (e *RealtimeEngine) authenticateTokenForDomainDB(bearerToken, domain string
 	hasher.Write([]byte(plainTextToken))
 	hashedToken := hex.EncodeToString(hasher.Sum(nil))
 
-	log.Printf("🔍 Authenticating token ID %d for tenant %s with hash: %s", tokenID, tenantInfo.Name, hashedToken[:16]+"...")
+	log.Printf("🔍 Authenticating token ID %d for tenant %s with hash: %s", tokenID, tenant.Name, hashedToken[:16]+"...")
 
 	// Validate the token in the specific tenant database
-	authSession, err := e.validateTokenInTenant(tenantInfo.Name, tenantDB, tokenID, hashedToken)
-	if err != nil {
-		return nil, fmt.Errorf("authentication failed for tenant %s: %w", tenantInfo.Name, err)
-	}
-
-	log.Printf("✅ Token authenticated for domain %s, tenant: %s, user: %d", domain, tenantInfo.Name, authSession.UserID)
-	return authSession, nil
+	return validateTokenInTenant(tenant.Name, db, tokenID, hashedToken)
 }
 
 // getTenantByDomain looks up tenant information by domain in the landlord database
@@ -112,7 +184,7 @@ func (e *RealtimeEngine) authenticateToken(bearerToken string) (*AuthenticatedSe
 }
 
 // validateTokenInTenant checks if a token exists and is valid in a specific tenant database
-func (e *RealtimeEngine) validateTokenInTenant(tenantName string, db *sql.DB, tokenID int, hashedToken string) (*AuthenticatedSession, error) {
+func validateTokenInTenant(tenantName string, db *sql.DB, tokenID int, hashedToken string) (*AuthenticatedSession, error) {
 	query := `
 		SELECT id, tokenable_type, tokenable_id, name, token, abilities, 
 		       last_used_at, expires_at, created_at, updated_at
@@ -162,14 +234,9 @@ func (e *RealtimeEngine) validateTokenInTenant(tenantName string, db *sql.DB, to
 		log.Printf("⚠️ Failed to update last_used_at for token %d: %v", tokenID, err)
 	}
 
-	// Parse abilities (Laravel stores as JSON array)
-	abilities := []string{}
-	if token.Abilities != "" {
-		// Simple parsing for ["*"] or ["ability1", "ability2"]
-		cleanAbilities := strings.Trim(token.Abilities, `[]"`)
-		if cleanAbilities != "" {
-			abilities = strings.Split(strings.ReplaceAll(cleanAbilities, `"`, ""), ",")
-		}
+	abilities, err := parseAbilities(token.Abilities)
+	if err != nil {
+		return nil, fmt.Errorf("token %d in tenant %s: %w", tokenID, tenantName, err)
 	}
 
 	return &AuthenticatedSession{
@@ -182,8 +249,36 @@ func (e *RealtimeEngine) validateTokenInTenant(tenantName string, db *sql.DB, to
 	}, nil
 }
 
-// hasAbility checks if the authenticated session has a specific ability
+// parseAbilities decodes the JSON array Laravel Sanctum stores in personal_access_tokens.abilities
+func parseAbilities(raw string) ([]string, error) {
+	abilities := []string{}
+	if strings.TrimSpace(raw) == "" {
+		return abilities, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &abilities); err != nil {
+		return nil, fmt.Errorf("invalid abilities %q: %w", raw, err)
+	}
+	return abilities, nil
+}
+
+// connectAbility returns the ability required to connect, empty when CONNECT_ABILITY is "none"
+func connectAbility() string {
+	if config.ConnectAbility == "none" {
+		return ""
+	}
+	return config.ConnectAbility
+}
+
+// tableAbility returns the ability required to read a table, empty when none is configured
+func tableAbility(table string) string {
+	return parseKeyValueList(config.TableAbilities)[table]
+}
+
+// hasAbility checks if the authenticated session has a specific ability (an empty one always passes)
 func (auth *AuthenticatedSession) hasAbility(ability string) bool {
+	if ability == "" {
+		return true
+	}
 	for _, a := range auth.Abilities {
 		if a == "*" || a == ability {
 			return true
@@ -192,6 +287,11 @@ func (auth *AuthenticatedSession) hasAbility(ability string) bool {
 	return false
 }
 
+// canReadTable checks if the session has the ability required to receive a table's rows
+func (auth *AuthenticatedSession) canReadTable(table string) bool {
+	return auth.hasAbility(tableAbility(table))
+}
+
 // canAccessTenant checks if the session can access a specific tenant's data
 func (auth *AuthenticatedSession) canAccessTenant(tenantName string) bool {
 	// User can only access their own tenant
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseAbilities(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", raw: "", want: []string{}},
+		{name: "blank", raw: "  ", want: []string{}},
+		{name: "wildcard", raw: `["*"]`, want: []string{"*"}},
+		{name: "several abilities", raw: `["realtime:subscribe","tasks:read"]`, want: []string{"realtime:subscribe", "tasks:read"}},
+		{name: "escaped strings", raw: `["tasks:\"read\"","caf\u00e9"]`, want: []string{`tasks:"read"`, "café"}},
+		{name: "comma inside an ability", raw: `["tasks:read,write"]`, want: []string{"tasks:read,write"}},
+		{name: "empty array", raw: `[]`, want: []string{}},
+		{name: "malformed JSON", raw: `["tasks:read"`, wantErr: true},
+		{name: "not an array", raw: `"tasks:read"`, wantErr: true},
+		{name: "not strings", raw: `[1, 2]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			abilities, err := parseAbilities(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAbilities(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(abilities, tt.want) {
+				t.Errorf("parseAbilities(%q) = %q, want %q", tt.raw, abilities, tt.want)
+			}
+		})
+	}
+}
+
+// TestConnectAbilityNoneIsSaved checks a disabled connect ability survives saving the configuration
+func TestConnectAbilityNoneIsSaved(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved })
+	t.Setenv("CONNECT_ABILITY", "none")
+	loadEngineConfig()
+
+	if ability := connectAbility(); ability != "" {
+		t.Fatalf("connectAbility() = %q, want none", ability)
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reloaded Config
+	if err := json.Unmarshal(data, &reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.ConnectAbility != "none" {
+		t.Errorf("saved connect_ability = %q, want none", reloaded.ConnectAbility)
+	}
+}
+
+func TestAuthenticateTokenFromCache(t *testing.T) {
+	output := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(output)
+
+	e := newTestEngine()
+	cached := &AuthenticatedSession{TenantName: "acme", UserID: 7, TokenKey: tokenKey("secret"), Authenticator: "jwt", Abilities: []string{"realtime:subscribe"}}
+	e.cacheToken("secret", "acme.example.com", cached)
+
+	first, err := e.authenticateTokenForDomain("secret", "acme.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := e.authenticateTokenForDomain("secret", "acme.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == cached || second == cached || first == second {
+		t.Fatal("sessions of one token share the cached authentication")
+	}
+
+	// What the auth command fills in must stay with its session
+	first.SessionID = "session-1"
+	first.Domain = "acme.example.com"
+	first.Abilities[0] = "changed"
+	if cached.SessionID != "" || cached.Domain != "" || cached.Abilities[0] != "realtime:subscribe" {
+		t.Errorf("cached authentication modified: %+v", cached)
+	}
+	if second.SessionID != "" || second.TokenKey != cached.TokenKey || !slices.Equal(second.Abilities, []string{"realtime:subscribe"}) {
+		t.Errorf("second session = %+v, want a fresh copy of the cached authentication", second)
+	}
+}
+
+func TestRevalidateExpiresJWTSessionsByToken(t *testing.T) {
+	output := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(output)
+
+	e := newTestEngine()
+	expiredAt := time.Now().Add(-time.Minute)
+	validUntil := time.Now().Add(time.Hour)
+	expired := &AuthenticatedSession{TenantName: "acme", UserID: 7, TokenKey: tokenKey("old"), Authenticator: "jwt", ExpiresAt: &expiredAt}
+	valid := &AuthenticatedSession{TenantName: "acme", UserID: 7, TokenKey: tokenKey("new"), Authenticator: "jwt", ExpiresAt: &validUntil}
+
+	// Two sessions of the expired token, each with its own copy, and one of another token
+	e.cacheToken("old", "acme.example.com", expired)
+	e.authenticatedSessions["a"] = expired.forNewSession()
+	e.authenticatedSessions["b"] = expired.forNewSession()
+	e.authenticatedSessions["c"] = valid.forNewSession()
+
+	e.revalidateTenantSessions("acme", nil)
+
+	if _, exists := e.authenticatedSessions["a"]; exists {
+		t.Error("session a of the expired token kept")
+	}
+	if _, exists := e.authenticatedSessions["b"]; exists {
+		t.Error("session b of the expired token kept")
+	}
+	if _, exists := e.authenticatedSessions["c"]; !exists {
+		t.Error("session c of a valid token expired")
+	}
+	if len(e.tokenCache) != 0 {
+		t.Errorf("expired token still cached: %d entries", len(e.tokenCache))
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/igm/sockjs-go/v3/sockjs"
+)
+
+// authFailure describes why a connection could not be authenticated
+type authFailure struct {
+	code    uint32 // close code
+	reason  string // close reason
+	message string // auth_error sent to the client
+}
+
+// authenticateConnection authenticates a bearer token for a domain and checks the connect ability
+func (e *RealtimeEngine) authenticateConnection(sessionID, token, domain string) (*AuthenticatedSession, *authFailure) {
+	authSession, err := e.authenticateTokenForDomain(token, domain)
+	if err != nil {
+		log.Printf("❌ Authentication failed for session %s (domain: %s): %v", sessionID, domain, err)
+		return nil, &authFailure{
+			code:    4001,
+			reason:  "Authentication failed",
+			message: fmt.Sprintf("Authentication failed for domain %s", domain),
+		}
+	}
+
+	if !authSession.hasAbility(connectAbility()) {
+		log.Printf("🔒 Session %s rejected: token lacks the '%s' ability (tenant: %s, user: %d)",
+			sessionID, connectAbility(), authSession.TenantName, authSession.UserID)
+		return nil, &authFailure{
+			code:    4003,
+			reason:  "Missing ability",
+			message: fmt.Sprintf("Token lacks the '%s' ability", connectAbility()),
+		}
+	}
+
+	authSession.SessionID = sessionID
+	authSession.Domain = domain
+	return authSession, nil
+}
+
+// awaitAuthentication holds a session that connected without a token until its first valid auth
+// command, closing it after AUTH_TIMEOUT_SECONDS. Returns the authenticated session and the
+// request id of the auth command, or nil when the session was closed.
+func (e *RealtimeEngine) awaitAuthentication(session sockjs.Session, domain string) (*AuthenticatedSession, string) {
+	// Tracked as negotiating, so shutdowns and zombie checks cover it
+	e.mutex.Lock()
+	e.negotiationSessions[session.ID()] = session
+	e.mutex.Unlock()
+
+	log.Printf("🔐 Session %s connected without token - waiting up to %ds for an auth command",
+		session.ID(), config.AuthTimeoutSeconds)
+
+	// Whoever settles first wins: the auth command or the timeout
+	var settled atomic.Bool
+	authTimeout := time.AfterFunc(time.Duration(config.AuthTimeoutSeconds)*time.Second, func() {
+		if settled.CompareAndSwap(false, true) {
+			log.Printf("⏰ Authentication timeout - closing session %s", session.ID())
+			e.sendAuthError(session, "Authentication timeout")
+			session.Close(4001, "Authentication timeout")
+		}
+	})
+	defer authTimeout.Stop()
+
+	for {
+		msg, err := session.Recv()
+		if err != nil {
+			e.mutex.Lock()
+			e.forgetSessionLocked(session.ID())
+			e.mutex.Unlock()
+			log.Printf("📡 Unauthenticated session %s disconnected: %v", session.ID(), err)
+			return nil, ""
+		}
+
+		var command ClientCommand
+		if err := json.Unmarshal([]byte(msg), &command); err != nil || command.Command != "auth" {
+			e.sendAuthError(session, "Authentication required: send an auth command first")
+			continue
+		}
+
+		token := strings.TrimPrefix(command.Token, "Bearer ")
+		if command.Domain != "" {
+			domain = command.Domain
+		}
+		if token == "" || domain == "" {
+			e.sendAuthError(session, "The auth command requires a token and a domain")
+			continue
+		}
+
+		authSession, failure := e.authenticateConnection(session.ID(), token, domain)
+		timedOut := !settled.CompareAndSwap(false, true)
+		if timedOut || failure != nil {
+			e.mutex.Lock()
+			e.forgetSessionLocked(session.ID())
+			e.mutex.Unlock()
+			if !timedOut {
+				e.sendAuthError(session, failure.message)
+				session.Close(failure.code, failure.reason)
+			}
+			return nil, ""
+		}
+		return authSession, command.RequestID
+	}
+}
+
+// handleAuthCommand rotates the token of an authenticated session. The new token must belong to
+// the same tenant and user; when it is rejected the session keeps its current token.
+func (e *RealtimeEngine) handleAuthCommand(session sockjs.Session, authSession *AuthenticatedSession, command ClientCommand) error {
+	token := strings.TrimPrefix(command.Token, "Bearer ")
+	if token == "" {
+		return e.sendCommandError(session, command, "The auth command requires a token")
+	}
+	domain := authSession.Domain
+	if command.Domain != "" {
+		domain = command.Domain
+	}
+
+	rotated, failure := e.authenticateConnection(session.ID(), token, domain)
+	if failure != nil {
+		e.sendAuthError(session, failure.message)
+		return nil
+	}
+	if rotated.TenantName != authSession.TenantName || rotated.UserID != authSession.UserID {
+		log.Printf("🔒 Session %s rejected token rotation to another user (tenant: %s, user: %d -> %d)",
+			session.ID(), authSession.TenantName, authSession.UserID, rotated.UserID)
+		e.sendAuthError(session, "The new token belongs to another user")
+		return nil
+	}
+
+	if !e.replaceSessionAuth(session.ID(), rotated) {
+		return nil
+	}
+	log.Printf("🔐 Session %s rotated its token (tenant: %s, user: %d)", session.ID(), rotated.TenantName, rotated.UserID)
+	return e.sendWelcome(session, rotated, "reauthenticated", command.RequestID)
+}
+
+// replaceSessionAuth swaps the authentication of a live session; false when it is gone
+func (e *RealtimeEngine) replaceSessionAuth(sessionID string, authSession *AuthenticatedSession) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if _, exists := e.authenticatedSessions[sessionID]; !exists {
+		return false
+	}
+	e.authenticatedSessions[sessionID] = authSession
+	e.setIndexedAuth(authSession.TenantName, sessionID, authSession)
+	return true
+}
+
+// currentSessionAuth returns the current authentication of a session, which an auth command may
+// have replaced, falling back to the given one
+func (e *RealtimeEngine) currentSessionAuth(sessionID string, authSession *AuthenticatedSession) *AuthenticatedSession {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	if current, exists := e.authenticatedSessions[sessionID]; exists {
+		return current
+	}
+	return authSession
+}
+
+// sendWelcome tells a session it is authenticated, with its tenant info and the stream position to resume from
+func (e *RealtimeEngine) sendWelcome(session sockjs.Session, authSession *AuthenticatedSession, operation, requestID string) error {
+	streamEpoch, lastSequence := e.getTenantStream(authSession.TenantName).Position()
+	data := map[string]interface{}{
+		"domain":      authSession.Domain,
+		"tenant_name": authSession.TenantName,
+		"user_id":     authSession.UserID,
+		"abilities":   authSession.Abilities,
+		"epoch":       streamEpoch,
+		"last_seq":    lastSequence,
+	}
+	if authSession.ExpiresAt != nil {
+		data["expires_at"] = authSession.ExpiresAt.Format(time.RFC3339)
+	}
+	if requestID != "" {
+		data["request_id"] = requestID
+	}
+
+	return e.sendToSession(session, SystemMessage{
+		Type:      "system",
+		Operation: operation,
+		Message:   fmt.Sprintf("Authenticated for domain: %s (tenant: %s)", authSession.Domain, authSession.TenantName),
+		Data:      data,
+		Timestamp: time.Now().Format(time.RFC3339),
+		SessionId: session.ID(),
+	})
+}
+
+// isAuthCommand tells whether a client message is an auth command, whose token must not be logged
+func isAuthCommand(msg string) bool {
+	var command struct {
+		Command string `json:"command"`
+	}
+	return json.Unmarshal([]byte(msg), &command) == nil && command.Command == "auth"
+}
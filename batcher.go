@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// changeBatcher groups consecutive changes of the same transaction before handing them on.
+// A batch is flushed when a change of another transaction arrives, when the source marks the
+// end of the transaction, when it reaches the size limit, or after a quiet window - sources
+// like NOTIFY cannot tell when a transaction's last change has arrived.
+type changeBatcher struct {
+	flush      func([]ChangeEvent)
+	pending    []ChangeEvent
+	ready      [][]ChangeEvent // complete batches waiting to be handed on, oldest first
+	delivering bool            // a caller is handing the ready batches on
+	timer      *time.Timer
+	mutex      sync.Mutex
+}
+
+// newChangeBatcher creates a batcher handing complete batches to flush
+func newChangeBatcher(flush func([]ChangeEvent)) *changeBatcher {
+	return &changeBatcher{flush: flush}
+}
+
+// Add queues a change (implements ChangeHandler)
+func (b *changeBatcher) Add(change ChangeEvent) {
+	b.mutex.Lock()
+	if len(b.pending) > 0 && (change.TxID == "" || change.TxID != b.pending[0].TxID) {
+		b.flushPendingLocked()
+	}
+
+	if change.TxID == "" {
+		// Changes without a transaction id are never grouped
+		b.ready = append(b.ready, []ChangeEvent{change})
+	} else {
+		b.pending = append(b.pending, change)
+		if change.TxEnd || len(b.pending) >= config.TxBatchMaxChanges {
+			b.flushPendingLocked()
+		} else if b.timer == nil {
+			b.timer = time.AfterFunc(time.Duration(config.TxBatchWindowMs)*time.Millisecond, b.flushAfterWindow)
+		} else {
+			b.timer.Reset(time.Duration(config.TxBatchWindowMs) * time.Millisecond)
+		}
+	}
+	b.mutex.Unlock()
+
+	b.deliver()
+}
+
+// flushAfterWindow flushes a transaction that received no change during the window
+func (b *changeBatcher) flushAfterWindow() {
+	b.mutex.Lock()
+	b.flushPendingLocked()
+	b.mutex.Unlock()
+
+	b.deliver()
+}
+
+// flushPendingLocked marks the pending changes as a complete batch; the caller holds the mutex
+func (b *changeBatcher) flushPendingLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	if len(b.pending) == 0 {
+		return
+	}
+	b.ready = append(b.ready, b.pending)
+	b.pending = nil
+}
+
+// deliver hands the ready batches on outside the mutex, so a broadcast never holds up Add.
+// Only one caller delivers at a time and batches leave in the order they were completed; a
+// batch completed meanwhile is delivered by the caller already delivering.
+func (b *changeBatcher) deliver() {
+	b.mutex.Lock()
+	if b.delivering {
+		b.mutex.Unlock()
+		return
+	}
+	b.delivering = true
+	for len(b.ready) > 0 {
+		batch := b.ready[0]
+		b.ready = b.ready[1:]
+		b.mutex.Unlock()
+		b.flush(batch)
+		b.mutex.Lock()
+	}
+	b.delivering = false
+	b.mutex.Unlock()
+}
+
+// Stop flushes the pending changes
+func (b *changeBatcher) Stop() {
+	b.mutex.Lock()
+	b.flushPendingLocked()
+	b.mutex.Unlock()
+
+	b.deliver()
+}
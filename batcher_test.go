@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// withBatchConfig sets the transaction batching settings for one test
+func withBatchConfig(t *testing.T, windowMs, maxChanges int) {
+	t.Helper()
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.TxBatchWindowMs = windowMs
+	config.TxBatchMaxChanges = maxChanges
+}
+
+// batchRecorder records the batches a batcher hands on, as the tables of their changes
+type batchRecorder struct {
+	batches [][]string
+	mutex   sync.Mutex
+}
+
+func (r *batchRecorder) flush(changes []ChangeEvent) {
+	tables := make([]string, 0, len(changes))
+	for _, change := range changes {
+		tables = append(tables, change.Table)
+	}
+	r.mutex.Lock()
+	r.batches = append(r.batches, tables)
+	r.mutex.Unlock()
+}
+
+func (r *batchRecorder) recorded() [][]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([][]string(nil), r.batches...)
+}
+
+func txChange(table, txID string, end bool) ChangeEvent {
+	return ChangeEvent{TenantName: "acme", Table: table, Operation: "INSERT", TxID: txID, TxEnd: end}
+}
+
+func TestChangeBatcherFlushTriggers(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxChanges int
+		changes    []ChangeEvent
+		want       [][]string // handed on before Stop
+	}{
+		{
+			name:    "open transaction waits",
+			changes: []ChangeEvent{txChange("a", "1", false), txChange("b", "1", false)},
+			want:    nil,
+		},
+		{
+			name:    "another transaction flushes the previous one",
+			changes: []ChangeEvent{txChange("a", "1", false), txChange("b", "1", false), txChange("c", "2", false)},
+			want:    [][]string{{"a", "b"}},
+		},
+		{
+			name:    "end of the transaction",
+			changes: []ChangeEvent{txChange("a", "1", false), txChange("b", "1", true), txChange("c", "2", true)},
+			want:    [][]string{{"a", "b"}, {"c"}},
+		},
+		{
+			name:       "size limit",
+			maxChanges: 2,
+			changes:    []ChangeEvent{txChange("a", "1", false), txChange("b", "1", false), txChange("c", "1", false)},
+			want:       [][]string{{"a", "b"}},
+		},
+		{
+			name:    "change without a transaction id is never grouped",
+			changes: []ChangeEvent{txChange("a", "1", false), txChange("b", "", false), txChange("c", "", false)},
+			want:    [][]string{{"a"}, {"b"}, {"c"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxChanges := tt.maxChanges
+			if maxChanges == 0 {
+				maxChanges = 1000
+			}
+			// A window long enough never to expire during the test
+			withBatchConfig(t, 60_000, maxChanges)
+
+			recorder := &batchRecorder{}
+			batcher := newChangeBatcher(recorder.flush)
+			for _, change := range tt.changes {
+				batcher.Add(change)
+			}
+			if got := recorder.recorded(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("batches = %v, want %v", got, tt.want)
+			}
+
+			// Stop hands on whatever is still pending
+			batcher.Stop()
+			handed := 0
+			for _, batch := range recorder.recorded() {
+				handed += len(batch)
+			}
+			if handed != len(tt.changes) {
+				t.Errorf("%d of %d changes handed on after Stop", handed, len(tt.changes))
+			}
+		})
+	}
+}
+
+func TestChangeBatcherWindow(t *testing.T) {
+	withBatchConfig(t, 10, 1000)
+
+	flushed := make(chan []ChangeEvent, 1)
+	batcher := newChangeBatcher(func(changes []ChangeEvent) { flushed <- changes })
+	batcher.Add(txChange("a", "1", false))
+	batcher.Add(txChange("b", "1", false))
+
+	select {
+	case batch := <-flushed:
+		if len(batch) != 2 {
+			t.Errorf("window flushed %d changes, want 2", len(batch))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("open transaction not flushed after the window")
+	}
+}
+
+// TestChangeBatcherOrder feeds transactions while the window timer flushes concurrently and
+// checks the stream numbers every change in the order it was added
+func TestChangeBatcherOrder(t *testing.T) {
+	withBatchConfig(t, 1, 1000)
+
+	stream := newTenantStream("acme", 10)
+	var sequences []uint64
+	var tables []string
+	batcher := newChangeBatcher(func(changes []ChangeEvent) {
+		for _, change := range changes {
+			message := stream.Append(PublicationMessage{Table: change.Table})
+			sequences = append(sequences, message.Sequence)
+			tables = append(tables, message.Table)
+		}
+		// A slow fan-out gives the timer and Add the chance to overlap
+		time.Sleep(100 * time.Microsecond)
+	})
+
+	var want []string
+	for tx := 0; tx < 50; tx++ {
+		for i := 0; i < 3; i++ {
+			table := fmt.Sprintf("t%d-%d", tx, i)
+			want = append(want, table)
+			batcher.Add(txChange(table, fmt.Sprint(tx), false))
+		}
+		if tx%5 == 0 {
+			time.Sleep(2 * time.Millisecond)
+		}
+	}
+	batcher.Stop()
+
+	// The last delivery may still run on the timer goroutine
+	deadline := time.Now().Add(time.Second)
+	for {
+		batcher.mutex.Lock()
+		idle := !batcher.delivering && len(batcher.ready) == 0
+		batcher.mutex.Unlock()
+		if idle || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if !reflect.DeepEqual(tables, want) {
+		t.Fatalf("changes handed on out of order:\n got %v\nwant %v", tables, want)
+	}
+	for i, sequence := range sequences {
+		if sequence != uint64(i+1) {
+			t.Fatalf("change %d got seq %d, want %d", i, sequence, i+1)
+		}
+	}
+}
+
+// TestChangeBatcherAddDuringDelivery checks Add does not wait for a slow delivery
+func TestChangeBatcherAddDuringDelivery(t *testing.T) {
+	withBatchConfig(t, 60_000, 1000)
+
+	release := make(chan struct{})
+	delivering := make(chan struct{})
+	var once sync.Once
+	batcher := newChangeBatcher(func(changes []ChangeEvent) {
+		once.Do(func() {
+			close(delivering)
+			<-release
+		})
+	})
+
+	go batcher.Add(txChange("a", "1", true))
+	<-delivering
+
+	added := make(chan struct{})
+	go func() {
+		batcher.Add(txChange("b", "2", true))
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("Add waited for the delivery of another batch")
+	}
+	close(release)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// BroadcastToTargets sends a system message to the active sessions of a tenant, or only to those of
+// some of its users and a team (teamID 0 = none). Returns the sessions reached per target
+// ("tenant:acme", "user:12", "team:3") and the number of distinct sessions reached.
+func (e *RealtimeEngine) BroadcastToTargets(tenantName string, userIDs []int, teamID int, msgType, operation, message string, data interface{}) (map[string]int, int, error) {
+	db, err := e.tenantDB(tenantName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Resolve the team before sending anything, so a failing query sends nothing
+	var teamMembers []int
+	if teamID != 0 {
+		if teamMembers, err = queryTeamMembers(db, teamID); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// Marshal once, the sessionId is added for each session
+	payload, err := json.Marshal(SystemMessage{
+		Type:      msgType,
+		Operation: operation,
+		Message:   message,
+		Data:      data,
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to marshal broadcast message: %w", err)
+	}
+
+	reached := make(map[string]int)
+	delivered := make(map[string]bool) // sessionID -> queued successfully; a session in several targets gets it once
+	deliver := func(target string, entries []sessionEntry) {
+		count := 0
+		for _, entry := range entries {
+			ok, queued := delivered[entry.id]
+			if !queued {
+				// The session's writer sends it after the publications queued before it
+				ok = entry.outbound.enqueueMessage(payload)
+				delivered[entry.id] = ok
+			}
+			if ok {
+				count++
+			}
+		}
+		reached[target] = count
+	}
+
+	if len(userIDs) == 0 && teamID == 0 {
+		deliver("tenant:"+tenantName, e.tenantSessionList(tenantName))
+	}
+	for _, userID := range userIDs {
+		deliver(fmt.Sprintf("user:%d", userID), e.userSessionList(tenantName, userID))
+	}
+	if teamID != 0 {
+		var entries []sessionEntry
+		for _, userID := range teamMembers {
+			entries = append(entries, e.userSessionList(tenantName, userID)...)
+		}
+		deliver(fmt.Sprintf("team:%d", teamID), entries)
+	}
+
+	total := 0
+	for _, ok := range delivered {
+		if ok {
+			total++
+		}
+	}
+	log.Printf("📡 Broadcasted system message to %d session(s) of tenant %s (targets: %v)", total, tenantName, reached)
+	return reached, total, nil
+}
+
+// queryTeamMembers returns the user ids of a team's members (TEAM_MEMBERS_QUERY)
+func queryTeamMembers(db *sql.DB, teamID int) ([]int, error) {
+	rows, err := db.Query(config.TeamMembersQuery, teamID)
+	if err != nil {
+		return nil, fmt.Errorf("team members query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var members []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("team members query failed: %w", err)
+		}
+		members = append(members, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("team members query failed: %w", err)
+	}
+	return members, nil
+}
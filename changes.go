@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// registerDefaultRowDecoders registers typed decoders for tables with a known record shape
+func (e *RealtimeEngine) registerDefaultRowDecoders() {
+	e.RegisterRowDecoder("wh_tasks", func(raw json.RawMessage) (interface{}, error) {
+		var task TaskRecord
+		if err := json.Unmarshal(raw, &task); err != nil {
+			return nil, err
+		}
+		return &task, nil
+	})
+}
+
+// RegisterRowDecoder registers a typed decoder for a table, replacing any existing one
+func (e *RealtimeEngine) RegisterRowDecoder(table string, decoder RowDecoder) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.rowDecoders[table] = decoder
+}
+
+// decodeRow decodes a raw row payload using the table's registered decoder,
+// falling back to a generic column map for tables without one
+func (e *RealtimeEngine) decodeRow(table string, raw json.RawMessage) (interface{}, error) {
+	if isNullJSON(raw) {
+		return nil, nil
+	}
+
+	e.mutex.RLock()
+	decoder, exists := e.rowDecoders[table]
+	e.mutex.RUnlock()
+
+	if exists {
+		return decoder(raw)
+	}
+	return decodeGenericRow(raw)
+}
+
+// decodeGenericRow decodes a row into a column map, keeping numbers as json.Number
+// so large bigint ids and numeric columns survive the round trip unchanged
+func decodeGenericRow(raw json.RawMessage) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var row map[string]interface{}
+	if err := decoder.Decode(&row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// rowValues returns the columns of a decoded row as a map, whatever its concrete type
+func rowValues(row interface{}) map[string]interface{} {
+	switch r := row.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		return r
+	}
+
+	raw, err := json.Marshal(row)
+	if err != nil {
+		return nil
+	}
+	values, err := decodeGenericRow(raw)
+	if err != nil {
+		return nil
+	}
+	return values
+}
+
+// rowLabel returns a human readable label for a row, preferring its name over its id
+func rowLabel(row interface{}) string {
+	values := rowValues(row)
+	if values == nil {
+		return "unknown"
+	}
+	if name, ok := values["name"].(string); ok && name != "" {
+		return name
+	}
+	if id, ok := values["id"]; ok && id != nil {
+		return fmt.Sprintf("#%v", id)
+	}
+	return "unknown"
+}
+
+// isNullJSON reports whether a raw JSON value is absent or an explicit null
+func isNullJSON(raw json.RawMessage) bool {
+	trimmed := bytes.TrimSpace(raw)
+	return len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null"))
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// notifyChannelName is the channel tenant triggers send their change notifications on
+const notifyChannelName = "whagons_tasks_changes"
+
+// identifierPattern matches plain (optionally schema-qualified) SQL identifiers
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// changeSourceNameForTenant returns the change source configured for a tenant
+func changeSourceNameForTenant(tenantName string) string {
+	if sourceName, exists := parseKeyValueList(config.ChangeSourceTenants)[tenantName]; exists {
+		return sourceName
+	}
+	return config.ChangeSource
+}
+
+// newChangeSource creates an unstarted change source by name
+func (e *RealtimeEngine) newChangeSource(sourceName string) (ChangeSource, error) {
+	switch sourceName {
+	case "notify", "":
+		return &notifyChangeSource{engine: e}, nil
+	case "replication":
+		return &replicationChangeSource{engine: e}, nil
+	case "outbox":
+		return &outboxChangeSource{engine: e}, nil
+	case "memory":
+		return NewMemoryChangeSource(), nil
+	}
+	return nil, fmt.Errorf("unknown change source: %s", sourceName)
+}
+
+// GetChangeSource returns the change source running for a tenant, e.g. to push events into a memory source
+func (e *RealtimeEngine) GetChangeSource(tenantName string) ChangeSource {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.changeSources[tenantName]
+}
+
+// tenantDB returns the connection pool of a tenant database
+func (e *RealtimeEngine) tenantDB(tenantName string) (*sql.DB, error) {
+	e.mutex.RLock()
+	db, exists := e.tenantDBs[tenantName]
+	e.mutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("database connection not found for tenant: %s", tenantName)
+	}
+	return db, nil
+}
+
+// notifyChangeSource receives changes sent by tenant triggers through PostgreSQL LISTEN/NOTIFY
+type notifyChangeSource struct {
+	engine   *RealtimeEngine
+	listener *pq.Listener
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func (s *notifyChangeSource) Name() string { return "notify" }
+
+// Start listens to the publication channel of the tenant database
+func (s *notifyChangeSource) Start(tenant TenantDB, emit ChangeHandler) error {
+	log.Printf("🎧 Starting publication listener for tenant: %s (database: %s)", tenant.Name, tenant.Database)
+
+	s.listener = pq.NewListener(
+		fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+			config.DBHost, config.DBPort, config.DBUsername, config.DBPassword, tenant.Database),
+		10*time.Second,
+		time.Minute,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("❌ PostgreSQL listener error for %s: %v", tenant.Name, err)
+			}
+		})
+
+	// Listen to the channel that corresponds to the publication
+	channelName := notifyChannelName
+	if err := s.listener.Listen(channelName); err != nil {
+		s.listener.Close()
+		return fmt.Errorf("failed to listen to channel %s: %w", channelName, err)
+	}
+
+	log.Printf("✅ Listening to channel '%s' for tenant: %s", channelName, tenant.Name)
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.run(tenant.Name, emit)
+	return nil
+}
+
+// run forwards notifications until the source is stopped
+func (s *notifyChangeSource) run(tenantName string, emit ChangeHandler) {
+	defer close(s.done)
+	defer s.listener.Close()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case notification := <-s.listener.Notify:
+			if notification != nil {
+				s.handleNotifications(tenantName, s.drainNotifications(notification), emit)
+			}
+		case <-time.After(90 * time.Second):
+			// Ping to keep connection alive
+			if err := s.listener.Ping(); err != nil {
+				log.Printf("❌ Ping failed for tenant %s: %v", tenantName, err)
+			}
+		}
+	}
+}
+
+// handleNotifications parses trigger payloads into change events, resolving reference payloads
+func (s *notifyChangeSource) handleNotifications(tenantName string, notifications []*pq.Notification, emit ChangeHandler) {
+	parsed := make([]PostgreSQLNotification, 0, len(notifications))
+	hasReferences := false
+	for _, notification := range notifications {
+		log.Printf("📡 Publication notification received from %s: %s", tenantName, notification.Extra)
+
+		// Parse the PostgreSQL notification payload once
+		var pgNotification PostgreSQLNotification
+		if err := json.Unmarshal([]byte(notification.Extra), &pgNotification); err != nil {
+			log.Printf("❌ Failed to parse notification JSON from %s: %v", tenantName, err)
+			continue
+		}
+		hasReferences = hasReferences || pgNotification.Reference
+		parsed = append(parsed, pgNotification)
+	}
+
+	if hasReferences {
+		parsed = s.resolveReferences(tenantName, parsed)
+	}
+
+	for _, pgNotification := range parsed {
+		emit(ChangeEvent{
+			TenantName: tenantName,
+			Source:     s.Name(),
+			Table:      pgNotification.Table,
+			Operation:  pgNotification.Operation,
+			NewData:    pgNotification.NewData,
+			OldData:    pgNotification.OldData,
+			Timestamp:  pgNotification.Timestamp,
+			TxID:       pgNotification.TxID.String(),
+		})
+	}
+}
+
+func (s *notifyChangeSource) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+}
+
+// outboxChangeSource polls an outbox table filled by tenant triggers and deletes rows once emitted
+type outboxChangeSource struct {
+	engine *RealtimeEngine
+	txid   string // column holding the writing transaction, NULL for tables created before it
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func (s *outboxChangeSource) Name() string { return "outbox" }
+
+// Start validates the outbox table and starts polling it
+func (s *outboxChangeSource) Start(tenant TenantDB, emit ChangeHandler) error {
+	if !identifierPattern.MatchString(config.OutboxTable) {
+		return fmt.Errorf("invalid outbox table name: %s", config.OutboxTable)
+	}
+
+	db, err := s.engine.tenantDB(tenant.Name)
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	if err := db.QueryRow("SELECT to_regclass($1) IS NOT NULL", config.OutboxTable).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check outbox table: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("outbox table %s does not exist in %s", config.OutboxTable, tenant.Database)
+	}
+
+	var hasTxID bool
+	if err := db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM pg_attribute WHERE attrelid = to_regclass($1) AND attname = 'txid' AND NOT attisdropped)`,
+		config.OutboxTable).Scan(&hasTxID); err != nil {
+		return fmt.Errorf("failed to check outbox table: %w", err)
+	}
+	s.txid = "txid::text"
+	if !hasTxID {
+		s.txid = "NULL::text"
+		log.Printf("⚠️  Outbox table %s of tenant %s has no txid column - its changes are not batched (see sql/tenant_outbox.sql)",
+			config.OutboxTable, tenant.Name)
+	}
+
+	log.Printf("🎧 Polling outbox table %s for tenant: %s", config.OutboxTable, tenant.Name)
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.run(tenant.Name, db, emit)
+	return nil
+}
+
+// run polls the outbox until the source is stopped
+func (s *outboxChangeSource) run(tenantName string, db *sql.DB, emit ChangeHandler) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(time.Duration(config.OutboxPollIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.poll(tenantName, db, emit); err != nil {
+				log.Printf("❌ Outbox poll failed for tenant %s: %v", tenantName, err)
+			}
+		}
+	}
+}
+
+// poll emits pending outbox rows in order and deletes them afterwards (at-least-once delivery)
+func (s *outboxChangeSource) poll(tenantName string, db *sql.DB, emit ChangeHandler) error {
+	query := fmt.Sprintf(`
+		SELECT id, table_name, operation, new_data, old_data, extract(epoch from created_at), %s
+		FROM %s
+		ORDER BY id
+		LIMIT 500`, s.txid, config.OutboxTable)
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return fmt.Errorf("failed to read outbox: %w", err)
+	}
+
+	var events []ChangeEvent
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var event ChangeEvent
+		var newData, oldData []byte
+		var txID sql.NullString
+		if err := rows.Scan(&id, &event.Table, &event.Operation, &newData, &oldData, &event.Timestamp, &txID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan outbox row: %w", err)
+		}
+		event.TenantName = tenantName
+		event.Source = s.Name()
+		event.NewData = newData
+		event.OldData = oldData
+		event.TxID = txID.String
+		events = append(events, event)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read outbox: %w", err)
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	for _, event := range events {
+		emit(event)
+	}
+
+	// Only delete the rows that were emitted: ids are assigned at insert, not at commit, so a
+	// lower id committed after the SELECT must stay for the next poll
+	deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE id = ANY($1)", config.OutboxTable)
+	if _, err := db.Exec(deleteQuery, pq.Array(ids)); err != nil {
+		return fmt.Errorf("failed to delete processed outbox rows: %w", err)
+	}
+	return nil
+}
+
+func (s *outboxChangeSource) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+}
+
+// replicationChangeSource consumes changes from a logical replication slot
+type replicationChangeSource struct {
+	engine *RealtimeEngine
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func (s *replicationChangeSource) Name() string { return "replication" }
+
+// Start prepares the replication slot and starts consuming it
+func (s *replicationChangeSource) Start(tenant TenantDB, emit ChangeHandler) error {
+	db, err := s.engine.tenantDB(tenant.Name)
+	if err != nil {
+		return err
+	}
+
+	consumer := newReplicationConsumer(tenant, db)
+	log.Printf("🎧 Starting replication listener for tenant: %s (database: %s, slot: %s, plugin: %s)",
+		tenant.Name, tenant.Database, consumer.slotName, consumer.plugin)
+
+	if err := consumer.ensureSlot(); err != nil {
+		return fmt.Errorf("failed to prepare replication slot %s: %w", consumer.slotName, err)
+	}
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		consumer.run(s.stop, emit)
+	}()
+	return nil
+}
+
+func (s *replicationChangeSource) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+}
+
+// MemoryChangeSource is an in-memory change source, useful for tests and local development
+type MemoryChangeSource struct {
+	events chan ChangeEvent
+	stop   chan struct{}
+	done   chan struct{}
+	mutex  sync.Mutex
+}
+
+// NewMemoryChangeSource creates an in-memory change source
+func NewMemoryChangeSource() *MemoryChangeSource {
+	return &MemoryChangeSource{
+		events: make(chan ChangeEvent, 100),
+	}
+}
+
+func (s *MemoryChangeSource) Name() string { return "memory" }
+
+// Start emits pushed events for the given tenant until stopped
+func (s *MemoryChangeSource) Start(tenant TenantDB, emit ChangeHandler) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			case event := <-s.events:
+				event.TenantName = tenant.Name
+				event.Source = s.Name()
+				if event.Timestamp == 0 {
+					event.Timestamp = float64(time.Now().UnixMicro()) / 1e6
+				}
+				emit(event)
+			}
+		}
+	}(s.stop, s.done)
+	return nil
+}
+
+// Push queues a change event to be emitted by the source
+func (s *MemoryChangeSource) Push(event ChangeEvent) {
+	s.events <- event
+}
+
+func (s *MemoryChangeSource) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
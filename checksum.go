@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/igm/sockjs-go/v3/sockjs"
+)
+
+// checksumColumns returns the columns hashed per row for a table
+func checksumColumns(table string) ([]string, error) {
+	columnList := config.ChecksumColumns
+	if tableColumns, exists := parseKeyValueList(config.ChecksumTableColumns)[table]; exists {
+		columnList = strings.ReplaceAll(tableColumns, "|", ",")
+	}
+
+	var columns []string
+	for _, column := range strings.Split(columnList, ",") {
+		column = strings.TrimSpace(column)
+		if column == "" {
+			continue
+		}
+		if !identifierPattern.MatchString(column) || strings.Contains(column, ".") {
+			return nil, fmt.Errorf("invalid checksum column: %s", column)
+		}
+		columns = append(columns, column)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no checksum columns configured for %s", table)
+	}
+	return columns, nil
+}
+
+// rowHashExpression builds the SQL expression hashing one row, given its to_jsonb() value.
+// Values are taken as they appear in change messages (to_jsonb(row)->>'column'), nulls as
+// empty strings, joined with '|' - so clients can compute the same hash from synced rows.
+func rowHashExpression(jsonbExpr string, columns []string) string {
+	parts := make([]string, len(columns))
+	for i, column := range columns {
+		parts[i] = fmt.Sprintf("coalesce(%s->>'%s', '')", jsonbExpr, column)
+	}
+	return "md5(" + strings.Join(parts, " || '|' || ") + ")"
+}
+
+// checksumWindow resolves the requested window: the whole table when full is set,
+// otherwise the given number of rows or the configured default
+func checksumWindow(window int, full bool) int {
+	if full {
+		return 0
+	}
+	if window <= 0 {
+		return config.ChecksumWindow
+	}
+	return window
+}
+
+// checksumTableError tells why a table's checksum cannot be given out, nil when it can. Only the
+// snapshot tables qualify, and not those with a row authorizer: a client only holds the rows it
+// may see, so a checksum over the whole table could never match and would reveal the others.
+func (e *RealtimeEngine) checksumTableError(table string) error {
+	if !e.isSnapshotTable(table) {
+		return fmt.Errorf("table %s is not available for checksums", table)
+	}
+	if e.rowAuthorizer(table) != nil {
+		return fmt.Errorf("table %s has row policies, checksums are not available for it", table)
+	}
+	return nil
+}
+
+// ValidateChecksumTable checks that a table's checksum may be computed (implements ChecksumEngineInterface)
+func (e *RealtimeEngine) ValidateChecksumTable(table string) error {
+	return e.checksumTableError(table)
+}
+
+// ComputeChecksum computes the checksum of a tenant table (implements ChecksumEngineInterface)
+func (e *RealtimeEngine) ComputeChecksum(tenantName, table string, window int, full bool, rangeSize int, since *uint64, epoch string) (interface{}, error) {
+	return e.computeTableChecksum(tenantName, table, checksumWindow(window, full), rangeSize, since, epoch)
+}
+
+// computeTableChecksum computes a deterministic checksum over a table, or over its most recently
+// updated rows when window > 0, inside a REPEATABLE READ snapshot. When the client sends the last
+// sequence it applied, the buffered changes up to the checksum's position are returned with it.
+func (e *RealtimeEngine) computeTableChecksum(tenantName, table string, window, rangeSize int, since *uint64, epoch string) (*TableChecksum, error) {
+	if !identifierPattern.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name: %s", table)
+	}
+	columns, err := checksumColumns(table)
+	if err != nil {
+		return nil, err
+	}
+	if window < 0 {
+		window = 0
+	}
+
+	db, err := e.tenantDB(tenantName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stream position before the snapshot, like snapshots
+	stream := e.getTenantStream(tenantName)
+	streamEpoch, sequence := stream.Position()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.SnapshotTimeoutSeconds)*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start checksum transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result := &TableChecksum{
+		Type:       "checksum",
+		TenantName: tenantName,
+		Table:      table,
+		Window:     window,
+		Columns:    columns,
+		Epoch:      streamEpoch,
+		Sequence:   sequence,
+		Timestamp:  time.Now().Format(time.RFC3339),
+	}
+
+	if err := tx.QueryRowContext(ctx, "SELECT pg_current_wal_lsn()::text").Scan(&result.LSN); err != nil {
+		result.LSN = ""
+	}
+
+	if err := e.queryChecksum(ctx, tx, result, rangeSize); err != nil {
+		return nil, err
+	}
+
+	// Changes the client still has to apply to reach the checksum's position
+	if since != nil {
+		messages, ok, _ := stream.Since(*since, epoch)
+		if !ok {
+			result.ResyncRequired = true
+		}
+		for _, message := range messages {
+			if message.Table == table && message.Sequence <= sequence {
+				result.ChangesSince = append(result.ChangesSince, message)
+			}
+		}
+	}
+
+	log.Printf("🧮 Checksum of %s.%s (window: %d, source: %s): %s over %d rows (seq %d)",
+		tenantName, table, window, result.Source, result.Checksum, result.RowCount, sequence)
+	return result, nil
+}
+
+// checksumRowsQuery returns the query listing (row_id, row_updated, row_hash) of a table:
+// from row_hashes when the engine maintains them for the table, otherwise hashed on the fly
+func (e *RealtimeEngine) checksumRowsQuery(tenantName, table string, columns []string, window int, args *[]interface{}) (string, string) {
+	updatedColumn := config.ChecksumUpdatedColumn
+
+	source := "table"
+	rowsQuery := fmt.Sprintf("SELECT t.id AS row_id, (to_jsonb(t)->>'%[1]s')::timestamptz AS row_updated, %[2]s AS row_hash FROM %[3]s t",
+		updatedColumn, rowHashExpression("to_jsonb(t)", columns), table)
+	if e.hasRowHashes(tenantName, table) {
+		source = "row_hashes"
+		*args = append(*args, table)
+		rowsQuery = fmt.Sprintf("SELECT row_id, last_updated AS row_updated, row_hash FROM row_hashes WHERE table_name = $%d", len(*args))
+	}
+
+	if window > 0 {
+		*args = append(*args, window)
+		rowsQuery = fmt.Sprintf("SELECT * FROM (%s) r ORDER BY row_updated DESC NULLS LAST, row_id DESC LIMIT $%d", rowsQuery, len(*args))
+	}
+	return rowsQuery, source
+}
+
+// queryChecksum aggregates the row hashes into the table checksum and, when a range size is
+// given, into one checksum per key range so clients can narrow down drift
+func (e *RealtimeEngine) queryChecksum(ctx context.Context, tx *sql.Tx, result *TableChecksum, rangeSize int) error {
+	var args []interface{}
+	rowsQuery, source := e.checksumRowsQuery(result.TenantName, result.Table, result.Columns, result.Window, &args)
+	result.Source = source
+
+	query := fmt.Sprintf(`
+		WITH rows AS (%s)
+		SELECT
+			coalesce(md5(string_agg(row_hash, '' ORDER BY row_id)), ''),
+			count(*),
+			(SELECT row_id::text FROM rows ORDER BY row_updated DESC NULLS LAST, row_id DESC LIMIT 1),
+			(SELECT to_jsonb(row_updated)#>>'{}' FROM rows ORDER BY row_updated DESC NULLS LAST, row_id DESC LIMIT 1)
+		FROM rows`, rowsQuery)
+
+	var lastRowID, lastUpdated sql.NullString
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&result.Checksum, &result.RowCount, &lastRowID, &lastUpdated); err != nil {
+		return fmt.Errorf("failed to compute checksum of %s: %w", result.Table, err)
+	}
+	if lastRowID.Valid {
+		result.LastRowID = &lastRowID.String
+	}
+	if lastUpdated.Valid {
+		result.LastUpdated = &lastUpdated.String
+	}
+
+	if rangeSize <= 0 {
+		return nil
+	}
+
+	result.RangeSize = rangeSize
+	args = append(args, rangeSize)
+	rangesQuery := fmt.Sprintf(`
+		WITH rows AS (%s)
+		SELECT floor(row_id::numeric / $%d)::bigint AS bucket, md5(string_agg(row_hash, '' ORDER BY row_id)), count(*)
+		FROM rows
+		GROUP BY bucket
+		ORDER BY bucket`, rowsQuery, len(args))
+
+	rows, err := tx.QueryContext(ctx, rangesQuery, args...)
+	if err != nil {
+		return fmt.Errorf("failed to compute range checksums of %s: %w", result.Table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket int64
+		var keyRange ChecksumRange
+		if err := rows.Scan(&bucket, &keyRange.Checksum, &keyRange.RowCount); err != nil {
+			return err
+		}
+		keyRange.From = bucket * int64(rangeSize)
+		keyRange.To = keyRange.From + int64(rangeSize) - 1
+		result.Ranges = append(result.Ranges, keyRange)
+	}
+	return rows.Err()
+}
+
+// handleChecksumCommand answers a checksum command sent over the socket
+func (e *RealtimeEngine) handleChecksumCommand(session sockjs.Session, authSession *AuthenticatedSession, command ClientCommand) error {
+	window := checksumWindow(command.Window, command.Full)
+	if err := e.checksumTableError(command.Table); err != nil {
+		return e.sendCommandError(session, command, err.Error())
+	}
+	if !authSession.canReadTable(command.Table) {
+		return e.sendCommandError(session, command, fmt.Sprintf("Missing ability to read %s", command.Table))
+	}
+	result, err := e.computeTableChecksum(authSession.TenantName, command.Table, window, command.RangeSize, command.Since, command.Epoch)
+	if err != nil {
+		log.Printf("❌ Checksum for session %s failed: %v", session.ID(), err)
+		return e.sendCommandError(session, command, "Failed to compute checksum")
+	}
+	result.RequestID = command.RequestID
+	return e.sendToSession(session, result)
+}
+
+// AuthenticateRequest authenticates an HTTP API request with a bearer token for a tenant domain
+// (implements ChecksumEngineInterface). Returns the tenant name, user id and token abilities.
+func (e *RealtimeEngine) AuthenticateRequest(bearerToken, domain string) (string, int, []string, error) {
+	if bearerToken == "" || domain == "" {
+		return "", 0, nil, fmt.Errorf("bearer token and domain are required")
+	}
+	authSession, err := e.authenticateTokenForDomain(bearerToken, domain)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	return authSession.TenantName, authSession.UserID, authSession.Abilities, nil
+}
+
+// CanReadTable checks token abilities against the connect ability and the ability a table
+// requires, like a socket session (implements ChecksumEngineInterface)
+func (e *RealtimeEngine) CanReadTable(abilities []string, table string) bool {
+	authSession := &AuthenticatedSession{Abilities: abilities}
+	return authSession.hasAbility(connectAbility()) && authSession.canReadTable(table)
+}
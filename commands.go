@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/igm/sockjs-go/v3/sockjs"
+)
+
+// allTables is the subscription wildcard matching every table of the tenant
+const allTables = "*"
+
+// handleClientMessage dispatches a message received from a client.
+// Returns an error only when the session can no longer be written to.
+func (e *RealtimeEngine) handleClientMessage(session sockjs.Session, authSession *AuthenticatedSession, msg string) error {
+	// Numbers stay json.Number, so filters on bigint ids keep every digit
+	decoder := json.NewDecoder(strings.NewReader(msg))
+	decoder.UseNumber()
+
+	var command ClientCommand
+	if err := decoder.Decode(&command); err != nil || command.Command == "" {
+		// Not a command - keep the legacy echo behaviour
+		return e.sendEcho(session, authSession, msg)
+	}
+
+	log.Printf("📥 Command '%s' from session %s (tenant: %s)", command.Command, session.ID(), authSession.TenantName)
+
+	switch command.Command {
+	case "auth":
+		return e.handleAuthCommand(session, authSession, command)
+	case "subscribe":
+		return e.handleSubscribe(session, authSession, command)
+	case "unsubscribe":
+		return e.handleUnsubscribe(session, command)
+	case "list_subscriptions":
+		return e.sendSubscriptions(session, command.RequestID, "subscriptions")
+	case "resume":
+		return e.handleResume(session, authSession, command)
+	case "snapshot":
+		return e.handleSnapshot(session, authSession, command)
+	case "checksum":
+		return e.handleChecksumCommand(session, authSession, command)
+	case "list_notifications":
+		return e.handleListNotifications(session, authSession, command)
+	case "ack_notifications":
+		return e.handleAckNotifications(session, authSession, command)
+	}
+
+	return e.sendCommandError(session, command, fmt.Sprintf("Unknown command: %s", command.Command))
+}
+
+// handleSubscribe adds tables to the session's subscriptions
+func (e *RealtimeEngine) handleSubscribe(session sockjs.Session, authSession *AuthenticatedSession, command ClientCommand) error {
+	if len(command.Tables) == 0 {
+		return e.sendCommandError(session, command, "At least one table is required")
+	}
+	for _, table := range command.Tables {
+		if table != allTables && !identifierPattern.MatchString(table) {
+			return e.sendCommandError(session, command, fmt.Sprintf("Invalid table name: %s", table))
+		}
+		// The wildcard is allowed; tables the token cannot read are left out when delivering
+		if table != allTables && !authSession.canReadTable(table) {
+			return e.sendCommandError(session, command, fmt.Sprintf("Missing ability to read %s", table))
+		}
+	}
+	if err := validEncoding(command.Encoding); err != nil {
+		return e.sendCommandError(session, command, err.Error())
+	}
+
+	now := time.Now()
+	newSubscriptions := make([]*TableSubscription, 0, len(command.Tables))
+	for _, table := range command.Tables {
+		subscription, err := newTableSubscription(table, command.Filter)
+		if err != nil {
+			return e.sendCommandError(session, command, err.Error())
+		}
+		subscription.Encoding = command.Encoding
+		subscription.SubscribedAt = now
+		newSubscriptions = append(newSubscriptions, subscription)
+	}
+
+	subscriptions := e.getOrCreateSubscriptions(session.ID())
+
+	subscriptions.mutex.Lock()
+	for _, subscription := range newSubscriptions {
+		subscriptions.Tables[subscription.Table] = subscription
+	}
+	subscriptions.mutex.Unlock()
+
+	log.Printf("🔔 Session %s subscribed to %s (filter: %v)", session.ID(), strings.Join(command.Tables, ", "), command.Filter)
+	return e.sendSubscriptions(session, command.RequestID, "subscribed")
+}
+
+// handleUnsubscribe removes tables (or all tables when none are given) from the session's subscriptions
+func (e *RealtimeEngine) handleUnsubscribe(session sockjs.Session, command ClientCommand) error {
+	// A legacy session receives every table, so a single table cannot be taken out of it
+	e.mutex.RLock()
+	_, subscribed := e.subscriptions[session.ID()]
+	e.mutex.RUnlock()
+	if !subscribed && len(command.Tables) > 0 {
+		return e.sendCommandError(session, command, fmt.Sprintf(
+			"Cannot unsubscribe from %s: the session receives every table, subscribe to the tables it needs instead",
+			strings.Join(command.Tables, ", ")))
+	}
+
+	subscriptions := e.getOrCreateSubscriptions(session.ID())
+
+	subscriptions.mutex.Lock()
+	// Neither can a table the wildcard subscription delivers
+	_, wildcard := subscriptions.Tables[allTables]
+	for _, table := range command.Tables {
+		if _, exists := subscriptions.Tables[table]; !exists && wildcard && table != allTables {
+			subscriptions.mutex.Unlock()
+			return e.sendCommandError(session, command, fmt.Sprintf(
+				"Cannot unsubscribe from %s: the %s subscription delivers it, unsubscribe from %s and subscribe to the tables needed instead",
+				table, allTables, allTables))
+		}
+	}
+	if len(command.Tables) == 0 {
+		subscriptions.Tables = make(map[string]*TableSubscription)
+	}
+	for _, table := range command.Tables {
+		delete(subscriptions.Tables, table)
+	}
+	subscriptions.mutex.Unlock()
+
+	log.Printf("🔕 Session %s unsubscribed from %s", session.ID(), strings.Join(command.Tables, ", "))
+	return e.sendSubscriptions(session, command.RequestID, "unsubscribed")
+}
+
+// getOrCreateSubscriptions returns the subscriptions of a session, creating an empty set on first use
+func (e *RealtimeEngine) getOrCreateSubscriptions(sessionID string) *SessionSubscriptions {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	subscriptions, exists := e.subscriptions[sessionID]
+	if !exists {
+		subscriptions = &SessionSubscriptions{Tables: make(map[string]*TableSubscription)}
+		e.subscriptions[sessionID] = subscriptions
+		if authSession, authenticated := e.authenticatedSessions[sessionID]; authenticated {
+			e.setIndexedSubscriptions(authSession.TenantName, sessionID, subscriptions)
+		}
+	}
+	return subscriptions
+}
+
+// sessionSubscriptionList returns a session's subscriptions sorted by table, or nil for legacy sessions
+func (e *RealtimeEngine) sessionSubscriptionList(sessionID string) []TableSubscription {
+	e.mutex.RLock()
+	subscriptions, exists := e.subscriptions[sessionID]
+	e.mutex.RUnlock()
+
+	if !exists {
+		return nil
+	}
+
+	subscriptions.mutex.RLock()
+	list := make([]TableSubscription, 0, len(subscriptions.Tables))
+	for _, subscription := range subscriptions.Tables {
+		list = append(list, *subscription)
+	}
+	subscriptions.mutex.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Table < list[j].Table })
+	return list
+}
+
+// sendSubscriptions sends the session's current subscriptions
+func (e *RealtimeEngine) sendSubscriptions(session sockjs.Session, requestID, operation string) error {
+	list := e.sessionSubscriptionList(session.ID())
+
+	tables := make([]string, 0, len(list))
+	for _, subscription := range list {
+		tables = append(tables, subscription.Table)
+	}
+
+	data := map[string]interface{}{
+		"tables":        tables,
+		"subscriptions": list,
+		"all_tables":    list == nil,
+	}
+	if requestID != "" {
+		data["request_id"] = requestID
+	}
+
+	return e.sendToSession(session, SystemMessage{
+		Type:      "subscription",
+		Operation: operation,
+		Message:   fmt.Sprintf("Subscribed to %d table(s)", len(tables)),
+		Data:      data,
+		Timestamp: time.Now().Format(time.RFC3339),
+		SessionId: session.ID(),
+	})
+}
+
+// sendCommandError reports an invalid command back to the client
+func (e *RealtimeEngine) sendCommandError(session sockjs.Session, command ClientCommand, message string) error {
+	log.Printf("⚠️  Command '%s' from session %s rejected: %s", command.Command, session.ID(), message)
+
+	data := map[string]interface{}{"command": command.Command}
+	if command.RequestID != "" {
+		data["request_id"] = command.RequestID
+	}
+
+	return e.sendToSession(session, SystemMessage{
+		Type:      "error",
+		Operation: "command_error",
+		Message:   message,
+		Data:      data,
+		Timestamp: time.Now().Format(time.RFC3339),
+		SessionId: session.ID(),
+	})
+}
+
+// sendEcho echoes a non-command message back to the client
+func (e *RealtimeEngine) sendEcho(session sockjs.Session, authSession *AuthenticatedSession, msg string) error {
+	response := SystemMessage{
+		Type:      "echo",
+		Operation: "echo",
+		Message:   fmt.Sprintf("Echo from %s: %s", authSession.TenantName, msg),
+		Data:      msg,
+		Timestamp: time.Now().Format(time.RFC3339),
+		SessionId: session.ID(),
+	}
+
+	if err := e.sendToSession(session, response); err != nil {
+		return err
+	}
+	log.Printf("📤 SockJS sent echo to active session %s", session.ID())
+	return nil
+}
+
+// emptySessionIDSuffix ends every message marshaled with an empty SessionId, its last field
+var emptySessionIDSuffix = []byte(`"sessionId":""}`)
+
+// addressedFrame addresses a message marshaled once with an empty SessionId to one session,
+// so broadcasts serialize each message once instead of once per session
+func addressedFrame(payload []byte, sessionID string) string {
+	if !bytes.HasSuffix(payload, emptySessionIDSuffix) {
+		return string(payload)
+	}
+	quotedID, _ := json.Marshal(sessionID)
+
+	var frame strings.Builder
+	frame.Grow(len(payload) + len(quotedID))
+	frame.Write(payload[:len(payload)-len(`""}`)])
+	frame.Write(quotedID)
+	frame.WriteByte('}')
+	return frame.String()
+}
+
+// sendToSession marshals and sends a message to a single session
+func (e *RealtimeEngine) sendToSession(session sockjs.Session, message interface{}) error {
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("❌ Failed to marshal message for session %s: %v", session.ID(), err)
+		return nil
+	}
+	if err := session.Send(string(messageJSON)); err != nil {
+		log.Printf("❌ SockJS send error: %v", err)
+		return err
+	}
+	return nil
+}
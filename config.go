@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/joho/godotenv"
@@ -20,6 +21,89 @@ type Config struct {
 	DBPassword string `json:"db_password"`
 	DBLandlord string `json:"db_landlord"`
 	ServerPort string `json:"server_port"`
+
+	// Change source settings
+	ChangeSource                 string `json:"change_source,omitempty"`                    // notify | replication | outbox | memory
+	ChangeSourceTenants          string `json:"change_source_tenants,omitempty"`            // per-tenant overrides: "tenant=source,..."
+	ReplicationPlugin            string `json:"replication_plugin,omitempty"`               // pgoutput | wal2json
+	ReplicationPublication       string `json:"replication_publication,omitempty"`          // publication streamed by pgoutput
+	ReplicationSlotPrefix        string `json:"replication_slot_prefix,omitempty"`          // slot name = prefix + "_" + tenant database
+	ReplicationPollIntervalMs    int    `json:"replication_poll_interval_ms,omitempty"`     // delay between slot polls
+	ReplicationMaxChangesPerPoll int    `json:"replication_max_changes_per_poll,omitempty"` // upper bound of changes decoded per poll
+	OutboxTable                  string `json:"outbox_table,omitempty"`                     // table polled by the outbox source
+	OutboxPollIntervalMs         int    `json:"outbox_poll_interval_ms,omitempty"`          // delay between outbox polls
+
+	// Trigger installation settings
+	TrackedTables string `json:"tracked_tables,omitempty"`  // tables given a notify trigger in each tenant database
+	TriggerDryRun bool   `json:"trigger_dry_run,omitempty"` // log the trigger SQL instead of executing it
+
+	// Notify payload settings
+	NotifyPayloadMode string `json:"notify_payload_mode,omitempty"` // full | reference (rows fetched by id)
+	NotifyBatchSize   int    `json:"notify_batch_size,omitempty"`   // notifications resolved per batch in reference mode
+	MessageEncoding   string `json:"message_encoding,omitempty"`    // full | delta: default encoding of UPDATE messages
+
+	// Transaction batching settings
+	TxBatching        bool `json:"tx_batching,omitempty"`          // deliver the changes of one transaction as a single batch message
+	TxBatchWindowMs   int  `json:"tx_batch_window_ms,omitempty"`   // wait for more changes of an open transaction
+	TxBatchMaxChanges int  `json:"tx_batch_max_changes,omitempty"` // upper bound of changes per batch message
+
+	// Outbound queue settings
+	OutboundQueue     bool `json:"outbound_queue,omitempty"`      // coalesce and rate-shape publications per session
+	OutboundQueueSize int  `json:"outbound_queue_size,omitempty"` // queued changes per session before it is evicted, 0 = unbounded
+	OutboundWindowMs  int  `json:"outbound_window_ms,omitempty"`  // coalescing window before a queued change is sent
+	OutboundMaxRate   int  `json:"outbound_max_rate,omitempty"`   // changes per second and session, 0 = unlimited
+
+	// Replay buffer settings
+	ReplayBufferSize int    `json:"replay_buffer_size,omitempty"` // changes kept per tenant for resuming clients
+	ReplayDir        string `json:"replay_dir,omitempty"`         // optional directory persisting replay buffers across restarts
+
+	// Snapshot settings
+	SnapshotPageSize       int    `json:"snapshot_page_size,omitempty"`       // default rows per snapshot chunk
+	SnapshotMaxPageSize    int    `json:"snapshot_max_page_size,omitempty"`   // upper bound for client-requested page sizes
+	SnapshotTimeoutSeconds int    `json:"snapshot_timeout_seconds,omitempty"` // maximum duration of a snapshot transaction
+	SnapshotTables         string `json:"snapshot_tables,omitempty"`          // tables clients may snapshot and checksum (default: tracked and decoded tables)
+
+	// Checksum settings
+	ChecksumColumns       string `json:"checksum_columns,omitempty"`        // columns hashed per row: "id,updated_at"
+	ChecksumTableColumns  string `json:"checksum_table_columns,omitempty"`  // per-table overrides: "table=col1|col2,..."
+	ChecksumUpdatedColumn string `json:"checksum_updated_column,omitempty"` // column ordering rows for windowed checksums
+	ChecksumWindow        int    `json:"checksum_window,omitempty"`         // rows in a recent-window checksum
+	RowHashTables         string `json:"row_hash_tables,omitempty"`         // tables whose row hashes are maintained by triggers
+
+	// Authorization settings
+	ConnectAbility string `json:"connect_ability,omitempty"` // Sanctum ability required to connect ("none" to disable)
+	TableAbilities string `json:"table_abilities,omitempty"` // ability required per table: "table=ability,..."
+
+	// Row-level authorization settings
+	RowPolicies           []string `json:"row_policies,omitempty"`             // "table.column=SELECT ... WHERE user_id = $1" entries
+	RowPolicyCacheSeconds int      `json:"row_policy_cache_seconds,omitempty"` // how long a user's policy result is reused
+
+	// Live session token checks
+	TokenRevalidateSeconds int  `json:"token_revalidate_seconds"`          // interval of token checks for live sessions, 0 = never
+	TokenRevocationNotify  bool `json:"token_revocation_notify,omitempty"` // react to token deletes through a trigger on personal_access_tokens
+
+	// Admin API settings
+	AdminAPIKeys string `json:"admin_api_keys,omitempty"` // admin HTTP API keys and their scopes: "key=read|broadcast,..."
+
+	// Targeted broadcast settings
+	TeamMembersQuery string `json:"team_members_query,omitempty"` // returns the user ids of the team given as $1
+
+	// User notification settings
+	Notifications               bool   `json:"notifications,omitempty"`                 // deliver new rows of the notifications table to their recipient
+	NotificationsTable          string `json:"notifications_table,omitempty"`           // Laravel database notifications table
+	NotificationsNotifiableType string `json:"notifications_notifiable_type,omitempty"` // notifiable_type of users
+
+	// Authenticator settings
+	AuthTimeoutSeconds   int    `json:"auth_timeout_seconds,omitempty"`  // time a session without token has to send an auth command
+	Authenticator        string `json:"authenticator,omitempty"`         // authenticators tried in order: "sanctum|jwt"
+	AuthenticatorTenants string `json:"authenticator_tenants,omitempty"` // per-tenant overrides: "tenant=jwt,..."
+	JWTSecret            string `json:"jwt_secret,omitempty"`            // HS256 signing secret
+	JWTJWKSFile          string `json:"jwt_jwks_file,omitempty"`         // JWKS file with the RS256 public keys
+	JWTIssuer            string `json:"jwt_issuer,omitempty"`            // required iss claim (empty = not checked)
+	JWTAudience          string `json:"jwt_audience,omitempty"`          // required aud claim (empty = not checked)
+	JWTUserClaim         string `json:"jwt_user_claim,omitempty"`        // claim holding the user id
+	JWTAbilitiesClaim    string `json:"jwt_abilities_claim,omitempty"`   // claim holding the abilities
+	JWTTenantClaim       string `json:"jwt_tenant_claim,omitempty"`      // claim that must match the tenant ("none" = not checked)
 }
 
 var config Config
@@ -27,7 +111,8 @@ var setupMode bool
 
 const configFileName = ".whagons-config.json"
 
-func init() {
+// initConfig parses the command line flags and loads the configuration, first thing in main
+func initConfig() {
 	// Parse command line flags
 	flag.BoolVar(&setupMode, "setup", false, "Run interactive setup to configure all variables")
 	flag.Parse()
@@ -83,6 +168,7 @@ func loadConfiguration() {
 		DBLandlord: getEnv("DB_LANDLORD", "landlord"),
 		ServerPort: getEnv("SERVER_PORT", "8082"),
 	}
+	loadEngineConfig()
 
 	// Final validation
 	if config.DBPassword == "" {
@@ -93,6 +179,93 @@ func loadConfiguration() {
 	log.Println("✅ Configuration loaded successfully")
 }
 
+// loadEngineConfig loads the engine settings (change sources, broadcasting, authentication...),
+// which are not part of the interactive setup
+func loadEngineConfig() {
+	config.ChangeSource = getEnv("CHANGE_SOURCE", "notify")
+	config.ChangeSourceTenants = getEnv("CHANGE_SOURCE_TENANTS", "")
+	config.ReplicationPlugin = getEnv("REPLICATION_PLUGIN", "pgoutput")
+	config.ReplicationPublication = getEnv("REPLICATION_PUBLICATION", "whagons_tasks_changes")
+	config.ReplicationSlotPrefix = getEnv("REPLICATION_SLOT_PREFIX", "whagons_rle")
+	config.ReplicationPollIntervalMs = getEnvInt("REPLICATION_POLL_INTERVAL_MS", 500)
+	config.ReplicationMaxChangesPerPoll = getEnvInt("REPLICATION_MAX_CHANGES_PER_POLL", 1000)
+	config.OutboxTable = getEnv("OUTBOX_TABLE", "whagons_outbox")
+	config.OutboxPollIntervalMs = getEnvInt("OUTBOX_POLL_INTERVAL_MS", 1000)
+
+	// Trigger installation
+	config.TrackedTables = getEnv("TRACKED_TABLES", "")
+	config.TriggerDryRun = getEnv("TRIGGER_DRY_RUN", "false") == "true"
+	config.NotifyPayloadMode = getEnv("NOTIFY_PAYLOAD_MODE", "full")
+	config.NotifyBatchSize = getEnvInt("NOTIFY_BATCH_SIZE", 100)
+	config.MessageEncoding = getEnv("MESSAGE_ENCODING", "full")
+
+	// Transaction batching
+	config.TxBatching = getEnv("TX_BATCHING", "false") == "true"
+	config.TxBatchWindowMs = getEnvInt("TX_BATCH_WINDOW_MS", 50)
+	config.TxBatchMaxChanges = getEnvInt("TX_BATCH_MAX_CHANGES", 1000)
+
+	// Outbound queues
+	config.OutboundQueue = getEnv("OUTBOUND_QUEUE", "false") == "true"
+	config.OutboundWindowMs = getEnvInt("OUTBOUND_WINDOW_MS", 25)
+	config.OutboundMaxRate = getEnvInt("OUTBOUND_MAX_RATE", 200)
+	config.OutboundQueueSize = getEnvInt("OUTBOUND_QUEUE_SIZE", 1000)
+
+	// Replay buffer
+	config.ReplayBufferSize = getEnvInt("REPLAY_BUFFER_SIZE", 1000)
+	config.ReplayDir = getEnv("REPLAY_DIR", "")
+
+	// Snapshots
+	config.SnapshotPageSize = getEnvInt("SNAPSHOT_PAGE_SIZE", 500)
+	config.SnapshotMaxPageSize = getEnvInt("SNAPSHOT_MAX_PAGE_SIZE", 5000)
+	config.SnapshotTimeoutSeconds = getEnvInt("SNAPSHOT_TIMEOUT_SECONDS", 300)
+	config.SnapshotTables = getEnv("SNAPSHOT_TABLES", "")
+
+	// Checksums
+	config.ChecksumColumns = getEnv("CHECKSUM_COLUMNS", "id,updated_at")
+	config.ChecksumTableColumns = getEnv("CHECKSUM_TABLE_COLUMNS", "")
+	config.ChecksumUpdatedColumn = getEnv("CHECKSUM_UPDATED_COLUMN", "updated_at")
+	config.ChecksumWindow = getEnvInt("CHECKSUM_WINDOW", 500)
+	config.RowHashTables = getEnv("ROW_HASH_TABLES", "")
+
+	// Abilities
+	config.ConnectAbility = getEnv("CONNECT_ABILITY", "realtime:subscribe") // kept as "none" so saving the config keeps it
+	config.TableAbilities = getEnv("TABLE_ABILITIES", "wh_tasks=tasks:read")
+
+	// Row policies
+	config.RowPolicies = parseRowPolicies(getEnv("ROW_POLICIES", ""))
+	config.RowPolicyCacheSeconds = getEnvInt("ROW_POLICY_CACHE_SECONDS", 60)
+
+	// Live session token checks
+	config.TokenRevalidateSeconds = getEnvInt("TOKEN_REVALIDATE_SECONDS", 60)
+	config.TokenRevocationNotify = getEnv("TOKEN_REVOCATION_NOTIFY", "false") == "true"
+
+	// Admin API
+	config.AdminAPIKeys = getEnv("ADMIN_API_KEYS", "")
+
+	// Targeted broadcasts
+	config.TeamMembersQuery = getEnv("TEAM_MEMBERS_QUERY", "SELECT user_id FROM wh_user_teams WHERE team_id = $1")
+
+	// User notifications
+	config.Notifications = getEnv("NOTIFICATIONS", "false") == "true"
+	config.NotificationsTable = getEnv("NOTIFICATIONS_TABLE", "notifications")
+	config.NotificationsNotifiableType = getEnv("NOTIFICATIONS_NOTIFIABLE_TYPE", `App\Models\User`)
+
+	// Authenticators
+	config.AuthTimeoutSeconds = getEnvInt("AUTH_TIMEOUT_SECONDS", 10)
+	config.Authenticator = getEnv("AUTHENTICATOR", "sanctum")
+	config.AuthenticatorTenants = getEnv("AUTHENTICATOR_TENANTS", "")
+	config.JWTSecret = getEnv("JWT_SECRET", "")
+	config.JWTJWKSFile = getEnv("JWT_JWKS_FILE", "")
+	config.JWTIssuer = getEnv("JWT_ISSUER", "")
+	config.JWTAudience = getEnv("JWT_AUDIENCE", "")
+	config.JWTUserClaim = getEnv("JWT_USER_CLAIM", "sub")
+	config.JWTAbilitiesClaim = getEnv("JWT_ABILITIES_CLAIM", "abilities")
+	config.JWTTenantClaim = getEnv("JWT_TENANT_CLAIM", "tenant")
+	if config.JWTTenantClaim == "none" {
+		config.JWTTenantClaim = ""
+	}
+}
+
 // runInteractiveSetup prompts user for all configuration values
 func runInteractiveSetup() {
 	log.Println("🛠️  Running interactive setup...")
@@ -111,6 +284,7 @@ func runInteractiveSetup() {
 			DBLandlord: "landlord",
 			ServerPort: "8082",
 		}
+		loadEngineConfig()
 
 		log.Println("⚠️  Database password not set - you'll need to:")
 		log.Println("   1. Create a .env file with DB_PASSWORD=your_password")
@@ -138,6 +312,7 @@ func runInteractiveSetup() {
 	config.DBPassword = promptWithDefault(reader, "Database Password", "")
 	config.DBLandlord = promptWithDefault(reader, "Landlord Database Name", "landlord")
 	config.ServerPort = promptWithDefault(reader, "Server Port", "8082")
+	loadEngineConfig()
 
 	// Save configuration
 	if err := saveToConfigFile(); err != nil {
@@ -189,6 +364,15 @@ func loadFromConfigFile() bool {
 		return false
 	}
 
+	// Settings whose zero value means something are applied whenever the file has them
+	var fileSettings struct {
+		TokenRevalidateSeconds *int `json:"token_revalidate_seconds"`
+	}
+	if err := json.Unmarshal(data, &fileSettings); err != nil {
+		log.Printf("⚠️  Error parsing %s: %v", configFileName, err)
+		return false
+	}
+
 	// Set environment variables from config file so getEnv() works
 	if fileConfig.DBHost != "" {
 		os.Setenv("DB_HOST", fileConfig.DBHost)
@@ -208,6 +392,167 @@ func loadFromConfigFile() bool {
 	if fileConfig.ServerPort != "" {
 		os.Setenv("SERVER_PORT", fileConfig.ServerPort)
 	}
+	if fileConfig.ChangeSource != "" {
+		os.Setenv("CHANGE_SOURCE", fileConfig.ChangeSource)
+	}
+	if fileConfig.ChangeSourceTenants != "" {
+		os.Setenv("CHANGE_SOURCE_TENANTS", fileConfig.ChangeSourceTenants)
+	}
+	if fileConfig.ReplicationPlugin != "" {
+		os.Setenv("REPLICATION_PLUGIN", fileConfig.ReplicationPlugin)
+	}
+	if fileConfig.ReplicationPublication != "" {
+		os.Setenv("REPLICATION_PUBLICATION", fileConfig.ReplicationPublication)
+	}
+	if fileConfig.ReplicationSlotPrefix != "" {
+		os.Setenv("REPLICATION_SLOT_PREFIX", fileConfig.ReplicationSlotPrefix)
+	}
+	if fileConfig.ReplicationPollIntervalMs > 0 {
+		os.Setenv("REPLICATION_POLL_INTERVAL_MS", strconv.Itoa(fileConfig.ReplicationPollIntervalMs))
+	}
+	if fileConfig.ReplicationMaxChangesPerPoll > 0 {
+		os.Setenv("REPLICATION_MAX_CHANGES_PER_POLL", strconv.Itoa(fileConfig.ReplicationMaxChangesPerPoll))
+	}
+	if fileConfig.OutboxTable != "" {
+		os.Setenv("OUTBOX_TABLE", fileConfig.OutboxTable)
+	}
+	if fileConfig.OutboxPollIntervalMs > 0 {
+		os.Setenv("OUTBOX_POLL_INTERVAL_MS", strconv.Itoa(fileConfig.OutboxPollIntervalMs))
+	}
+	if fileConfig.TrackedTables != "" {
+		os.Setenv("TRACKED_TABLES", fileConfig.TrackedTables)
+	}
+	if fileConfig.TriggerDryRun {
+		os.Setenv("TRIGGER_DRY_RUN", "true")
+	}
+	if fileConfig.NotifyPayloadMode != "" {
+		os.Setenv("NOTIFY_PAYLOAD_MODE", fileConfig.NotifyPayloadMode)
+	}
+	if fileConfig.NotifyBatchSize > 0 {
+		os.Setenv("NOTIFY_BATCH_SIZE", strconv.Itoa(fileConfig.NotifyBatchSize))
+	}
+	if fileConfig.MessageEncoding != "" {
+		os.Setenv("MESSAGE_ENCODING", fileConfig.MessageEncoding)
+	}
+	if fileConfig.TxBatching {
+		os.Setenv("TX_BATCHING", "true")
+	}
+	if fileConfig.TxBatchWindowMs > 0 {
+		os.Setenv("TX_BATCH_WINDOW_MS", strconv.Itoa(fileConfig.TxBatchWindowMs))
+	}
+	if fileConfig.TxBatchMaxChanges > 0 {
+		os.Setenv("TX_BATCH_MAX_CHANGES", strconv.Itoa(fileConfig.TxBatchMaxChanges))
+	}
+	if fileConfig.OutboundQueue {
+		os.Setenv("OUTBOUND_QUEUE", "true")
+	}
+	if fileConfig.OutboundWindowMs > 0 {
+		os.Setenv("OUTBOUND_WINDOW_MS", strconv.Itoa(fileConfig.OutboundWindowMs))
+	}
+	if fileConfig.OutboundMaxRate > 0 {
+		os.Setenv("OUTBOUND_MAX_RATE", strconv.Itoa(fileConfig.OutboundMaxRate))
+	}
+	if fileConfig.OutboundQueueSize > 0 {
+		os.Setenv("OUTBOUND_QUEUE_SIZE", strconv.Itoa(fileConfig.OutboundQueueSize))
+	}
+	if fileConfig.ReplayBufferSize > 0 {
+		os.Setenv("REPLAY_BUFFER_SIZE", strconv.Itoa(fileConfig.ReplayBufferSize))
+	}
+	if fileConfig.ReplayDir != "" {
+		os.Setenv("REPLAY_DIR", fileConfig.ReplayDir)
+	}
+	if fileConfig.SnapshotPageSize > 0 {
+		os.Setenv("SNAPSHOT_PAGE_SIZE", strconv.Itoa(fileConfig.SnapshotPageSize))
+	}
+	if fileConfig.SnapshotMaxPageSize > 0 {
+		os.Setenv("SNAPSHOT_MAX_PAGE_SIZE", strconv.Itoa(fileConfig.SnapshotMaxPageSize))
+	}
+	if fileConfig.SnapshotTimeoutSeconds > 0 {
+		os.Setenv("SNAPSHOT_TIMEOUT_SECONDS", strconv.Itoa(fileConfig.SnapshotTimeoutSeconds))
+	}
+	if fileConfig.SnapshotTables != "" {
+		os.Setenv("SNAPSHOT_TABLES", fileConfig.SnapshotTables)
+	}
+	if fileConfig.ChecksumColumns != "" {
+		os.Setenv("CHECKSUM_COLUMNS", fileConfig.ChecksumColumns)
+	}
+	if fileConfig.ChecksumTableColumns != "" {
+		os.Setenv("CHECKSUM_TABLE_COLUMNS", fileConfig.ChecksumTableColumns)
+	}
+	if fileConfig.ChecksumUpdatedColumn != "" {
+		os.Setenv("CHECKSUM_UPDATED_COLUMN", fileConfig.ChecksumUpdatedColumn)
+	}
+	if fileConfig.ChecksumWindow > 0 {
+		os.Setenv("CHECKSUM_WINDOW", strconv.Itoa(fileConfig.ChecksumWindow))
+	}
+	if fileConfig.RowHashTables != "" {
+		os.Setenv("ROW_HASH_TABLES", fileConfig.RowHashTables)
+	}
+	if fileConfig.ConnectAbility != "" {
+		os.Setenv("CONNECT_ABILITY", fileConfig.ConnectAbility)
+	}
+	if fileConfig.TableAbilities != "" {
+		os.Setenv("TABLE_ABILITIES", fileConfig.TableAbilities)
+	}
+	if len(fileConfig.RowPolicies) > 0 {
+		if policies, err := json.Marshal(fileConfig.RowPolicies); err == nil {
+			os.Setenv("ROW_POLICIES", string(policies))
+		}
+	}
+	if fileConfig.RowPolicyCacheSeconds > 0 {
+		os.Setenv("ROW_POLICY_CACHE_SECONDS", strconv.Itoa(fileConfig.RowPolicyCacheSeconds))
+	}
+	if fileSettings.TokenRevalidateSeconds != nil {
+		os.Setenv("TOKEN_REVALIDATE_SECONDS", strconv.Itoa(*fileSettings.TokenRevalidateSeconds))
+	}
+	if fileConfig.TokenRevocationNotify {
+		os.Setenv("TOKEN_REVOCATION_NOTIFY", "true")
+	}
+	if fileConfig.AdminAPIKeys != "" {
+		os.Setenv("ADMIN_API_KEYS", fileConfig.AdminAPIKeys)
+	}
+	if fileConfig.TeamMembersQuery != "" {
+		os.Setenv("TEAM_MEMBERS_QUERY", fileConfig.TeamMembersQuery)
+	}
+	if fileConfig.Notifications {
+		os.Setenv("NOTIFICATIONS", "true")
+	}
+	if fileConfig.NotificationsTable != "" {
+		os.Setenv("NOTIFICATIONS_TABLE", fileConfig.NotificationsTable)
+	}
+	if fileConfig.NotificationsNotifiableType != "" {
+		os.Setenv("NOTIFICATIONS_NOTIFIABLE_TYPE", fileConfig.NotificationsNotifiableType)
+	}
+	if fileConfig.AuthTimeoutSeconds > 0 {
+		os.Setenv("AUTH_TIMEOUT_SECONDS", strconv.Itoa(fileConfig.AuthTimeoutSeconds))
+	}
+	if fileConfig.Authenticator != "" {
+		os.Setenv("AUTHENTICATOR", fileConfig.Authenticator)
+	}
+	if fileConfig.AuthenticatorTenants != "" {
+		os.Setenv("AUTHENTICATOR_TENANTS", fileConfig.AuthenticatorTenants)
+	}
+	if fileConfig.JWTSecret != "" {
+		os.Setenv("JWT_SECRET", fileConfig.JWTSecret)
+	}
+	if fileConfig.JWTJWKSFile != "" {
+		os.Setenv("JWT_JWKS_FILE", fileConfig.JWTJWKSFile)
+	}
+	if fileConfig.JWTIssuer != "" {
+		os.Setenv("JWT_ISSUER", fileConfig.JWTIssuer)
+	}
+	if fileConfig.JWTAudience != "" {
+		os.Setenv("JWT_AUDIENCE", fileConfig.JWTAudience)
+	}
+	if fileConfig.JWTUserClaim != "" {
+		os.Setenv("JWT_USER_CLAIM", fileConfig.JWTUserClaim)
+	}
+	if fileConfig.JWTAbilitiesClaim != "" {
+		os.Setenv("JWT_ABILITIES_CLAIM", fileConfig.JWTAbilitiesClaim)
+	}
+	if fileConfig.JWTTenantClaim != "" {
+		os.Setenv("JWT_TENANT_CLAIM", fileConfig.JWTTenantClaim)
+	}
 
 	return true
 }
@@ -230,6 +575,46 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvInt gets an integer environment variable with fallback to default
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("⚠️  Invalid integer for %s (%q), using default %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// parseList parses a comma-separated setting, skipping empty entries
+func parseList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// parseKeyValueList parses "key=value,key2=value2" settings into a map
+func parseKeyValueList(value string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		key, val, found := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			continue
+		}
+		result[key] = strings.TrimSpace(val)
+	}
+	return result
+}
+
 // isInteractive checks if the application is running in an interactive terminal
 func isInteractive() bool {
 	// Check if stdin is a terminal
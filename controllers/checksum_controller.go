@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ChecksumController handles table integrity checksum endpoints
+type ChecksumController struct {
+	engine ChecksumEngineInterface
+}
+
+// ChecksumEngineInterface defines the methods we need from RealtimeEngine for checksums
+type ChecksumEngineInterface interface {
+	AuthenticateRequest(bearerToken, domain string) (string, int, []string, error)
+	CanReadTable(abilities []string, table string) bool
+	ValidateChecksumTable(table string) error
+	ComputeChecksum(tenantName, table string, window int, full bool, rangeSize int, since *uint64, epoch string) (interface{}, error)
+}
+
+// ChecksumRequest represents the request body for computing a table checksum
+type ChecksumRequest struct {
+	Table     string  `json:"table" binding:"required" example:"wh_tasks"`
+	Full      bool    `json:"full" example:"false"`
+	Window    int     `json:"window,omitempty" example:"500"`
+	RangeSize int     `json:"range_size,omitempty" example:"1000"`
+	Since     *uint64 `json:"since,omitempty" example:"1042"`
+	Epoch     string  `json:"epoch,omitempty"`
+	Domain    string  `json:"domain,omitempty" example:"acme.whagons.com"`
+}
+
+// NewChecksumController creates a new checksum controller
+func NewChecksumController(engine ChecksumEngineInterface) *ChecksumController {
+	return &ChecksumController{
+		engine: engine,
+	}
+}
+
+// ComputeChecksum computes a checksum over a table of the caller's tenant
+// @Summary Compute table checksum
+// @Description Computes a deterministic hash over a tenant table (or its most recently updated rows) for client integrity checks
+// @Tags checksum
+// @Accept json
+// @Produce json
+// @Param request body ChecksumRequest true "Checksum request"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /api/checksum [post]
+func (cc *ChecksumController) ComputeChecksum(c *fiber.Ctx) error {
+	var requestBody ChecksumRequest
+
+	if err := c.BodyParser(&requestBody); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid JSON request body",
+			"error":   err.Error(),
+		})
+	}
+
+	if requestBody.Table == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Table field is required",
+		})
+	}
+
+	// Same credentials as the socket: bearer token plus the tenant domain
+	bearerToken := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+	domain := c.Get("X-Tenant-Domain")
+	if domain == "" {
+		domain = c.Query("domain", requestBody.Domain)
+	}
+
+	tenantName, _, abilities, err := cc.engine.AuthenticateRequest(bearerToken, domain)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication failed",
+			"error":   err.Error(),
+		})
+	}
+
+	if err := cc.engine.ValidateChecksumTable(requestBody.Table); err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Checksum not available",
+			"error":   err.Error(),
+		})
+	}
+
+	if !cc.engine.CanReadTable(abilities, requestBody.Table) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Token lacks the ability to read " + requestBody.Table,
+		})
+	}
+
+	checksum, err := cc.engine.ComputeChecksum(tenantName, requestBody.Table, requestBody.Window, requestBody.Full, requestBody.RangeSize, requestBody.Since, requestBody.Epoch)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to compute checksum",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   checksum,
+	})
+}
@@ -19,6 +19,8 @@ type HealthEngineInterface interface {
 	GetTenantDatabasesCount() int
 	IsLandlordConnected() bool
 	GetCacheStats() map[string]int
+	GetOutboundStats() map[string]int
+	GetTenantSessionCounts() map[string]int
 }
 
 // NewHealthController creates a new health controller
@@ -35,6 +37,7 @@ func NewHealthController(engine HealthEngineInterface) *HealthController {
 // @Accept json
 // @Produce json
 // @Success 200 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
 // @Router /api/health [get]
 func (hc *HealthController) GetHealth(c *fiber.Ctx) error {
 	activeSessionCount := hc.engine.GetConnectedSessionsCount()
@@ -74,6 +77,8 @@ func (hc *HealthController) GetHealth(c *fiber.Ctx) error {
 // @Accept json
 // @Produce json
 // @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
 // @Router /api/metrics [get]
 func (hc *HealthController) GetMetrics(c *fiber.Ctx) error {
 	activeSessionCount := hc.engine.GetConnectedSessionsCount()
@@ -82,6 +87,7 @@ func (hc *HealthController) GetMetrics(c *fiber.Ctx) error {
 	tenantCount := hc.engine.GetTenantDatabasesCount()
 	landlordConnected := hc.engine.IsLandlordConnected()
 	cacheStats := hc.engine.GetCacheStats()
+	outboundStats := hc.engine.GetOutboundStats()
 
 	response := fiber.Map{
 		"status": "success",
@@ -90,12 +96,14 @@ func (hc *HealthController) GetMetrics(c *fiber.Ctx) error {
 				"active_count":      activeSessionCount,
 				"negotiation_count": negotiationSessionCount,
 				"total_count":       totalSessionCount,
+				"per_tenant":        hc.engine.GetTenantSessionCounts(),
 			},
 			"databases": fiber.Map{
 				"tenant_count":       tenantCount,
 				"landlord_connected": landlordConnected,
 			},
 			"auth_cache": cacheStats,
+			"outbound":   outboundStats,
 			"system": fiber.Map{
 				"uptime":  time.Now().Format(time.RFC3339),
 				"service": "WhagonsRLE",
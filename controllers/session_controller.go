@@ -18,6 +18,7 @@ type RealtimeEngineInterface interface {
 	GetTotalSessionsCount() int
 	DisconnectAllSessions()
 	BroadcastMessage(msgType, operation, message string, data interface{})
+	BroadcastToTargets(tenantName string, userIDs []int, teamID int, msgType, operation, message string, data interface{}) (map[string]int, int, error)
 	ReloadTenants() error
 	TestTenantNotification() error
 }
@@ -46,6 +47,8 @@ func NewSessionController(engine RealtimeEngineInterface) *SessionController {
 // @Accept json
 // @Produce json
 // @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
 // @Router /api/sessions/count [get]
 func (sc *SessionController) GetSessionsCount(c *fiber.Ctx) error {
 	activeCount := sc.engine.GetConnectedSessionsCount()
@@ -72,6 +75,8 @@ func (sc *SessionController) GetSessionsCount(c *fiber.Ctx) error {
 // @Accept json
 // @Produce json
 // @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
 // @Router /api/sessions/disconnect-all [post]
 func (sc *SessionController) DisconnectAllSessions(c *fiber.Ctx) error {
 	// Get count before disconnecting
@@ -96,15 +101,17 @@ func (sc *SessionController) DisconnectAllSessions(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(response)
 }
 
-// BroadcastMessage sends a message to all connected sessions
-// @Summary Broadcast message to all sessions
-// @Description Sends a message to all currently connected WebSocket sessions
+// BroadcastMessage sends a message to all connected sessions, or only to those of a tenant, its users or a team
+// @Summary Broadcast message to sessions
+// @Description Sends a message to all currently connected WebSocket sessions, or to the sessions of a tenant, user ids or team
 // @Tags sessions
 // @Accept json
 // @Produce json
 // @Param message body BroadcastRequest true "Broadcast message request"
 // @Success 200 {object} map[string]interface{}
 // @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
 // @Router /api/broadcast [post]
 func (sc *SessionController) BroadcastMessage(c *fiber.Ctx) error {
 	var requestBody BroadcastRequest
@@ -132,6 +139,20 @@ func (sc *SessionController) BroadcastMessage(c *fiber.Ctx) error {
 		requestBody.Operation = "broadcast"
 	}
 
+	userIDs := requestBody.UserIDs
+	if requestBody.UserID != nil {
+		userIDs = append([]int{*requestBody.UserID}, userIDs...)
+	}
+	if requestBody.Tenant != "" {
+		return sc.broadcastToTargets(c, requestBody, userIDs)
+	}
+	if len(userIDs) > 0 || requestBody.TeamID != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Tenant field is required for user and team targets",
+		})
+	}
+
 	// Get session count before broadcasting
 	activeSessionCount := sc.engine.GetConnectedSessionsCount()
 	negotiationSessionCount := sc.engine.GetNegotiationSessionsCount()
@@ -165,6 +186,45 @@ func (sc *SessionController) BroadcastMessage(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(response)
 }
 
+// broadcastToTargets sends a broadcast to a tenant, or to some of its users and a team
+func (sc *SessionController) broadcastToTargets(c *fiber.Ctx, requestBody BroadcastRequest, userIDs []int) error {
+	teamID := 0
+	if requestBody.TeamID != nil {
+		teamID = *requestBody.TeamID
+	}
+
+	targets, sessionsReached, err := sc.engine.BroadcastToTargets(requestBody.Tenant, userIDs, teamID,
+		requestBody.Type, requestBody.Operation, requestBody.Message, requestBody.Data)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to broadcast message",
+			"error":   err.Error(),
+		})
+	}
+
+	systemMessage := SystemMessage{
+		Type:      requestBody.Type,
+		Operation: requestBody.Operation,
+		Message:   requestBody.Message,
+		Data:      requestBody.Data,
+		Timestamp: time.Now().Format(time.RFC3339),
+		SessionId: "", // Will be set per session by the engine
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Message broadcasted successfully",
+		"data": fiber.Map{
+			"tenant":                  requestBody.Tenant,
+			"targets":                 targets,
+			"active_sessions_reached": sessionsReached,
+			"broadcast_message":       systemMessage,
+			"timestamp":               time.Now().Format(time.RFC3339),
+		},
+	})
+}
+
 // ReloadTenants checks for new tenants and connects to them
 // @Summary Reload tenants
 // @Description Checks for new tenants in the landlord database and connects to them
@@ -172,6 +232,8 @@ func (sc *SessionController) BroadcastMessage(c *fiber.Ctx) error {
 // @Accept json
 // @Produce json
 // @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
 // @Failure 500 {object} map[string]interface{}
 // @Router /api/tenants/reload [post]
 func (sc *SessionController) ReloadTenants(c *fiber.Ctx) error {
@@ -202,6 +264,8 @@ func (sc *SessionController) ReloadTenants(c *fiber.Ctx) error {
 // @Accept json
 // @Produce json
 // @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
 // @Failure 500 {object} map[string]interface{}
 // @Router /api/tenants/test-notification [post]
 func (sc *SessionController) TestTenantNotification(c *fiber.Ctx) error {
@@ -231,4 +295,8 @@ type BroadcastRequest struct {
 	Operation string      `json:"operation" example:"broadcast"`
 	Message   string      `json:"message" binding:"required" example:"Hello all connected clients!"`
 	Data      interface{} `json:"data,omitempty"`
+	Tenant    string      `json:"tenant,omitempty" example:"acme"` // only sessions of this tenant (required for user and team targets)
+	UserID    *int        `json:"user_id,omitempty" example:"12"`  // only sessions of this user
+	UserIDs   []int       `json:"user_ids,omitempty"`              // only sessions of these users
+	TeamID    *int        `json:"team_id,omitempty" example:"3"`   // only sessions of this team's members
 }
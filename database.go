@@ -176,7 +176,7 @@ func (e *RealtimeEngine) reloadTenantDatabases() error {
 			log.Printf("✅ Connected to new tenant database: %s", tenant.Name)
 
 			// Start publication listener for the new tenant
-			go e.listenToTenantPublications(tenant.Name, tenant.Database)
+			go e.startTenantChangeSource(tenant)
 			newTenantsCount++
 		}
 	}
@@ -329,6 +329,10 @@ func (e *RealtimeEngine) handleTenantChangeNotification(notification *pq.Notific
 		case "DELETE":
 			if payload.OldData != nil {
 				log.Printf("➖ Tenant deleted: %s", payload.OldData.Name)
+				// Stop streaming changes before the connection goes away
+				e.stopTenantChangeSource(payload.OldData.Name)
+				e.stopTenantListener(payload.OldData.Name)
+
 				// Close connection to deleted tenant
 				e.mutex.Lock()
 				if db, exists := e.tenantDBs[payload.OldData.Name]; exists {
@@ -336,6 +340,7 @@ func (e *RealtimeEngine) handleTenantChangeNotification(notification *pq.Notific
 						log.Printf("⚠️  Error closing deleted tenant database %s: %v", payload.OldData.Name, err)
 					}
 					delete(e.tenantDBs, payload.OldData.Name)
+					delete(e.rowHashTables, payload.OldData.Name)
 					log.Printf("🗑️  Disconnected from deleted tenant: %s", payload.OldData.Name)
 				}
 				e.mutex.Unlock()
@@ -375,6 +380,28 @@ func (e *RealtimeEngine) connectToTenant(tenant TenantDB) error {
 	e.tenantDBs[tenant.Name] = db
 	e.mutex.Unlock()
 
+	// Install the notify triggers of the tracked tables
+	if err := e.setupTenantTriggers(tenant, db); err != nil {
+		log.Printf("⚠️  Failed to setup notify triggers for tenant %s: %v", tenant.Name, err)
+	}
+
+	// React to revoked tokens of live sessions
+	if err := e.setupTokenRevocation(tenant, db); err != nil {
+		log.Printf("⚠️  Failed to setup token revocation trigger for tenant %s: %v", tenant.Name, err)
+		log.Printf("🔍 Revoked tokens are detected every %d seconds", config.TokenRevalidateSeconds)
+	}
+
+	// Deliver user notifications to their recipients
+	if err := e.setupNotifications(tenant, db); err != nil {
+		log.Printf("⚠️  Failed to setup notification trigger for tenant %s: %v", tenant.Name, err)
+	}
+
+	// Set up incremental row hashes for checksums
+	if err := e.setupTenantRowHashes(tenant.Name, db); err != nil {
+		log.Printf("⚠️  Failed to setup row hashes for tenant %s: %v", tenant.Name, err)
+		log.Println("🔍 Checksums will hash table rows on the fly")
+	}
+
 	return nil
 }
 
@@ -413,7 +440,7 @@ func (e *RealtimeEngine) connectToTenantWithRetry(tenant TenantDB) {
 		log.Printf("✅ Connected to new tenant: %s (attempt %d)", tenant.Name, attempt)
 
 		// Start publication listener for the new tenant
-		go e.listenToTenantPublications(tenant.Name, tenant.Database)
+		go e.startTenantChangeSource(tenant)
 		return
 	}
 }
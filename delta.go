@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// deltaMessage builds the delta encoding of an UPDATE: new_data only holds the primary key and
+// the columns whose value changed, old_data is left out. Returns nil when there is no old row
+// to diff against (e.g. replication without REPLICA IDENTITY FULL keeps only the key).
+func deltaMessage(message PublicationMessage, newValues, oldValues map[string]interface{}) *PublicationMessage {
+	if message.Operation != "UPDATE" || newValues == nil || len(oldValues) <= 1 {
+		return nil
+	}
+	key, hasKey := newValues[snapshotKeyColumn]
+	if !hasKey {
+		return nil
+	}
+
+	changes := map[string]interface{}{snapshotKeyColumn: key}
+	changed := make([]string, 0)
+	for column, value := range newValues {
+		if oldValue, exists := oldValues[column]; exists && reflect.DeepEqual(oldValue, value) {
+			continue
+		}
+		changes[column] = value
+		changed = append(changed, column)
+	}
+	sort.Strings(changed)
+
+	delta := message
+	delta.Encoding = "delta"
+	delta.NewData = changes
+	delta.OldData = nil
+	delta.ChangedColumns = changed
+	return &delta
+}
+
+// validEncoding checks a message encoding requested by a client
+func validEncoding(encoding string) error {
+	switch encoding {
+	case "", "full", "delta":
+		return nil
+	}
+	return fmt.Errorf("unknown encoding: %s (expected full or delta)", encoding)
+}
+
+// encodeMessage applies the encoding of the session's subscription to a routed message.
+// delta is the precomputed delta encoding of the change, nil when not applicable.
+func (subscriptions *SessionSubscriptions) encodeMessage(message PublicationMessage, delta *PublicationMessage) PublicationMessage {
+	if delta == nil || message.Operation != "UPDATE" {
+		return message
+	}
+
+	encoding := config.MessageEncoding
+	if subscription, subscribed := subscriptions.subscriptionFor(message.Table); subscribed && subscription.Encoding != "" {
+		encoding = subscription.Encoding
+	}
+	if encoding != "delta" {
+		return message
+	}
+	return *delta
+}
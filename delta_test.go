@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestDeltaMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		newValues map[string]interface{}
+		oldValues map[string]interface{}
+		wantNil   bool
+		newData   map[string]interface{}
+		changed   []string
+	}{
+		{
+			name:      "changed columns and the key",
+			operation: "UPDATE",
+			newValues: map[string]interface{}{"id": 42, "name": "Boiler", "status_id": 3, "team_id": 7},
+			oldValues: map[string]interface{}{"id": 42, "name": "Boiler", "status_id": 2, "team_id": 6},
+			newData:   map[string]interface{}{"id": 42, "status_id": 3, "team_id": 7},
+			changed:   []string{"status_id", "team_id"},
+		},
+		{
+			name:      "nothing changed",
+			operation: "UPDATE",
+			newValues: map[string]interface{}{"id": 42, "name": "Boiler"},
+			oldValues: map[string]interface{}{"id": 42, "name": "Boiler"},
+			newData:   map[string]interface{}{"id": 42},
+			changed:   []string{},
+		},
+		{
+			name:      "column missing from the old row counts as changed",
+			operation: "UPDATE",
+			newValues: map[string]interface{}{"id": 42, "name": "Boiler", "notes": "new"},
+			oldValues: map[string]interface{}{"id": 42, "name": "Boiler"},
+			newData:   map[string]interface{}{"id": 42, "notes": "new"},
+			changed:   []string{"notes"},
+		},
+		{
+			name:      "nested values are compared deeply",
+			operation: "UPDATE",
+			newValues: map[string]interface{}{"id": 42, "tags": []interface{}{"a", "b"}, "meta": map[string]interface{}{"x": json.Number("1")}},
+			oldValues: map[string]interface{}{"id": 42, "tags": []interface{}{"a"}, "meta": map[string]interface{}{"x": json.Number("1")}},
+			newData:   map[string]interface{}{"id": 42, "tags": []interface{}{"a", "b"}},
+			changed:   []string{"tags"},
+		},
+		{
+			name:      "old row with only the key",
+			operation: "UPDATE",
+			newValues: map[string]interface{}{"id": 42, "name": "Boiler"},
+			oldValues: map[string]interface{}{"id": 42},
+			wantNil:   true,
+		},
+		{
+			name:      "no old row",
+			operation: "UPDATE",
+			newValues: map[string]interface{}{"id": 42, "name": "Boiler"},
+			wantNil:   true,
+		},
+		{
+			name:      "no key column",
+			operation: "UPDATE",
+			newValues: map[string]interface{}{"uuid": "a", "name": "Boiler"},
+			oldValues: map[string]interface{}{"uuid": "a", "name": "Old"},
+			wantNil:   true,
+		},
+		{
+			name:      "insert",
+			operation: "INSERT",
+			newValues: map[string]interface{}{"id": 42, "name": "Boiler"},
+			oldValues: map[string]interface{}{"id": 42, "name": "Old"},
+			wantNil:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := PublicationMessage{Type: "database", Table: "tasks", Operation: tt.operation, NewData: tt.newValues, OldData: tt.oldValues, Sequence: 5}
+			delta := deltaMessage(message, tt.newValues, tt.oldValues)
+			if tt.wantNil {
+				if delta != nil {
+					t.Errorf("deltaMessage() = %+v, want nil", delta)
+				}
+				return
+			}
+			if delta == nil {
+				t.Fatal("deltaMessage() = nil")
+			}
+			if delta.Encoding != "delta" || delta.OldData != nil || delta.Sequence != 5 {
+				t.Errorf("deltaMessage() encoding %q, old data %v, seq %d, want delta without old data, seq 5", delta.Encoding, delta.OldData, delta.Sequence)
+			}
+			if !reflect.DeepEqual(delta.NewData, tt.newData) {
+				t.Errorf("deltaMessage() new data = %v, want %v", delta.NewData, tt.newData)
+			}
+			if !slices.Equal(delta.ChangedColumns, tt.changed) {
+				t.Errorf("deltaMessage() changed columns = %v, want %v", delta.ChangedColumns, tt.changed)
+			}
+			if message.Encoding != "" || message.OldData == nil {
+				t.Error("deltaMessage() modified the original message")
+			}
+		})
+	}
+}
+
+func TestEncodeMessage(t *testing.T) {
+	newValues := map[string]interface{}{"id": 42, "status_id": 3}
+	oldValues := map[string]interface{}{"id": 42, "status_id": 2}
+	update := PublicationMessage{Table: "tasks", Operation: "UPDATE", NewData: newValues, OldData: oldValues}
+	delta := deltaMessage(update, newValues, oldValues)
+
+	tests := []struct {
+		name          string
+		defaultEncode string
+		encoding      string // of the session's tasks subscription, "legacy" for a session without subscriptions
+		message       PublicationMessage
+		delta         *PublicationMessage
+		want          string
+	}{
+		{name: "server default full", defaultEncode: "full", encoding: "", message: update, delta: delta, want: ""},
+		{name: "server default delta", defaultEncode: "delta", encoding: "", message: update, delta: delta, want: "delta"},
+		{name: "subscription asks for delta", defaultEncode: "full", encoding: "delta", message: update, delta: delta, want: "delta"},
+		{name: "subscription asks for full", defaultEncode: "delta", encoding: "full", message: update, delta: delta, want: ""},
+		{name: "legacy session uses the server default", defaultEncode: "delta", encoding: "legacy", message: update, delta: delta, want: "delta"},
+		{name: "no delta available", defaultEncode: "delta", encoding: "delta", message: update, delta: nil, want: ""},
+		{name: "LEAVE stays full", defaultEncode: "delta", encoding: "delta", message: leaveMessage(update, "left your filter"), delta: delta, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := config
+			t.Cleanup(func() { config = saved })
+			config.MessageEncoding = tt.defaultEncode
+
+			var subscriptions *SessionSubscriptions
+			if tt.encoding != "legacy" {
+				subscription, _ := newTableSubscription("tasks", nil)
+				subscription.Encoding = tt.encoding
+				subscriptions = subscriptionsOf(subscription)
+			}
+
+			encoded := subscriptions.encodeMessage(tt.message, tt.delta)
+			if encoded.Encoding != tt.want || encoded.Operation != tt.message.Operation {
+				t.Errorf("encodeMessage() = %s %q, want %s %q", encoded.Operation, encoded.Encoding, tt.message.Operation, tt.want)
+			}
+		})
+	}
+}
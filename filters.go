@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// newTableSubscription validates a subscribe filter and builds the subscription for a table
+func newTableSubscription(table string, filter map[string]interface{}) (*TableSubscription, error) {
+	subscription := &TableSubscription{
+		Table:   table,
+		Filter:  filter,
+		allowed: make(map[string]map[string]bool, len(filter)),
+	}
+
+	for column, value := range filter {
+		if !identifierPattern.MatchString(column) {
+			return nil, fmt.Errorf("invalid filter column: %s", column)
+		}
+
+		values, isList := value.([]interface{})
+		if !isList {
+			values = []interface{}{value}
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("filter on %s has no values", column)
+		}
+
+		allowed := make(map[string]bool, len(values))
+		for _, v := range values {
+			key, ok := filterValueKey(v)
+			if !ok {
+				return nil, fmt.Errorf("unsupported filter value for %s: %v", column, v)
+			}
+			allowed[key] = true
+		}
+		subscription.allowed[column] = allowed
+	}
+
+	return subscription, nil
+}
+
+// matches checks whether a row satisfies every column predicate of the subscription
+func (subscription *TableSubscription) matches(row map[string]interface{}) bool {
+	if len(subscription.allowed) == 0 {
+		return true
+	}
+	if row == nil {
+		return false
+	}
+
+	for column, allowed := range subscription.allowed {
+		value, exists := row[column]
+		if !exists {
+			return false
+		}
+		key, ok := filterValueKey(value)
+		if !ok || !allowed[key] {
+			return false
+		}
+	}
+	return true
+}
+
+// filterValueKey normalizes scalar values so 5, 5.0, "5"-as-json.Number compare equal.
+// Integer-valued numbers are keyed by their exact digits, so bigint ids beyond 2^53 stay apart;
+// only fractional numbers go through float64.
+func filterValueKey(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "null", true
+	case string:
+		return "s:" + v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return "n:" + strconv.FormatFloat(v, 'f', -1, 64), true
+	case int:
+		return "n:" + strconv.Itoa(v), true
+	case json.Number:
+		if exact, ok := new(big.Rat).SetString(v.String()); ok && exact.IsInt() {
+			return "n:" + exact.Num().String(), true
+		}
+		if f, err := v.Float64(); err == nil {
+			return "n:" + strconv.FormatFloat(f, 'f', -1, 64), true
+		}
+		return "n:" + v.String(), true
+	}
+	return "", false
+}
+
+// subscriptionFor returns the subscription that applies to a table, preferring an exact
+// table subscription over the wildcard. Legacy sessions (nil) match every table unfiltered.
+func (subscriptions *SessionSubscriptions) subscriptionFor(table string) (*TableSubscription, bool) {
+	if subscriptions == nil {
+		return &TableSubscription{Table: allTables}, true
+	}
+
+	subscriptions.mutex.RLock()
+	defer subscriptions.mutex.RUnlock()
+
+	if subscription, exists := subscriptions.Tables[table]; exists {
+		return subscription, true
+	}
+	subscription, exists := subscriptions.Tables[allTables]
+	return subscription, exists
+}
+
+// routeMessage decides whether and how a change is delivered to a session.
+// newValues/oldValues are the message rows as column maps, computed once per change.
+// An UPDATE moving a row out of the session's filter is delivered as a LEAVE event.
+func (subscriptions *SessionSubscriptions) routeMessage(message PublicationMessage, newValues, oldValues map[string]interface{}) (PublicationMessage, bool) {
+	subscription, subscribed := subscriptions.subscriptionFor(message.Table)
+	if !subscribed {
+		return message, false
+	}
+	if len(subscription.allowed) == 0 {
+		return message, true
+	}
+
+	switch message.Operation {
+	case "INSERT":
+		return message, subscription.matches(newValues)
+	case "DELETE":
+		return message, subscription.matches(oldValues)
+	case "UPDATE":
+		if subscription.matches(newValues) {
+			return message, true
+		}
+		if subscription.matches(oldValues) {
+			return leaveMessage(message, "left your filter"), true
+		}
+	}
+	return message, false
+}
+
+// leaveMessage turns an UPDATE into a LEAVE carrying only the old row, for a row that is no
+// longer visible to the session
+func leaveMessage(message PublicationMessage, reason string) PublicationMessage {
+	message.Operation = "LEAVE"
+	message.NewData = nil
+	message.Message = fmt.Sprintf("%s row '%s' %s", message.Table, rowLabel(message.OldData), reason)
+	return message
+}
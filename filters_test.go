@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNewTableSubscription(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  map[string]interface{}
+		wantErr bool
+	}{
+		{name: "no filter", filter: nil},
+		{name: "scalar value", filter: map[string]interface{}{"team_id": float64(7)}},
+		{name: "value list", filter: map[string]interface{}{"status_id": []interface{}{float64(1), "2", nil, true}}},
+		{name: "invalid column", filter: map[string]interface{}{"team_id; DROP TABLE": float64(7)}, wantErr: true},
+		{name: "empty value list", filter: map[string]interface{}{"team_id": []interface{}{}}, wantErr: true},
+		{name: "object value", filter: map[string]interface{}{"team_id": map[string]interface{}{"gt": 1}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTableSubscription("tasks", tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newTableSubscription() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTableSubscriptionMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter map[string]interface{}
+		row    map[string]interface{}
+		want   bool
+	}{
+		{name: "no filter matches any row", filter: nil, row: map[string]interface{}{"id": 1}, want: true},
+		{name: "no filter matches a missing row", filter: nil, row: nil, want: true},
+		{name: "filter never matches a missing row", filter: map[string]interface{}{"team_id": float64(7)}, row: nil, want: false},
+		{name: "float filter, int value", filter: map[string]interface{}{"team_id": float64(7)}, row: map[string]interface{}{"team_id": 7}, want: true},
+		{name: "float filter, json.Number value", filter: map[string]interface{}{"team_id": float64(7)}, row: map[string]interface{}{"team_id": json.Number("7.0")}, want: true},
+		{name: "number filter, string value", filter: map[string]interface{}{"team_id": float64(7)}, row: map[string]interface{}{"team_id": "7"}, want: false},
+		{name: "different value", filter: map[string]interface{}{"team_id": float64(7)}, row: map[string]interface{}{"team_id": 8}, want: false},
+		{name: "missing column", filter: map[string]interface{}{"team_id": float64(7)}, row: map[string]interface{}{"id": 1}, want: false},
+		{name: "value in list", filter: map[string]interface{}{"status_id": []interface{}{float64(1), float64(2)}}, row: map[string]interface{}{"status_id": 2}, want: true},
+		{name: "null in list", filter: map[string]interface{}{"assignee": []interface{}{nil, "bob"}}, row: map[string]interface{}{"assignee": nil}, want: true},
+		{name: "bool", filter: map[string]interface{}{"done": false}, row: map[string]interface{}{"done": false}, want: true},
+		{
+			name:   "every column must match",
+			filter: map[string]interface{}{"team_id": float64(7), "done": false},
+			row:    map[string]interface{}{"team_id": 7, "done": true},
+			want:   false,
+		},
+		{name: "bigint ids differing above 2^53", filter: map[string]interface{}{"id": json.Number("9007199254740993")}, row: map[string]interface{}{"id": json.Number("9007199254740992")}, want: false},
+		{name: "same bigint id", filter: map[string]interface{}{"id": json.Number("9007199254740993")}, row: map[string]interface{}{"id": json.Number("9007199254740993")}, want: true},
+		{name: "bigint id beyond int64", filter: map[string]interface{}{"id": json.Number("92233720368547758070")}, row: map[string]interface{}{"id": json.Number("92233720368547758071")}, want: false},
+		{name: "integer-valued json.Number", filter: map[string]interface{}{"team_id": json.Number("7")}, row: map[string]interface{}{"team_id": json.Number("7.00")}, want: true},
+		{name: "fractional json.Number", filter: map[string]interface{}{"price": float64(2.5)}, row: map[string]interface{}{"price": json.Number("2.50")}, want: true},
+		{name: "unsupported row value", filter: map[string]interface{}{"tags": "a"}, row: map[string]interface{}{"tags": []interface{}{"a"}}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subscription, err := newTableSubscription("tasks", tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := subscription.matches(tt.row); got != tt.want {
+				t.Errorf("matches(%v) = %v, want %v", tt.row, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteMessage(t *testing.T) {
+	inTeam := map[string]interface{}{"id": 42, "name": "Boiler", "team_id": 7}
+	otherTeam := map[string]interface{}{"id": 42, "name": "Boiler", "team_id": 8}
+
+	filtered, err := newTableSubscription("tasks", map[string]interface{}{"team_id": float64(7)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unfiltered, _ := newTableSubscription("tasks", nil)
+	wildcard, _ := newTableSubscription(allTables, nil)
+
+	tests := []struct {
+		name          string
+		subscriptions *SessionSubscriptions
+		table         string
+		operation     string
+		newData       map[string]interface{}
+		oldData       map[string]interface{}
+		deliver       bool
+		wantOperation string
+	}{
+		{name: "legacy session receives every table", subscriptions: nil, table: "invoices", operation: "INSERT", newData: otherTeam, deliver: true, wantOperation: "INSERT"},
+		{name: "unsubscribed table", subscriptions: subscriptionsOf(filtered), table: "invoices", operation: "INSERT", newData: inTeam, deliver: false},
+		{name: "wildcard subscription", subscriptions: subscriptionsOf(wildcard), table: "invoices", operation: "DELETE", oldData: otherTeam, deliver: true, wantOperation: "DELETE"},
+		{name: "table subscription wins over the wildcard", subscriptions: subscriptionsOf(wildcard, filtered), table: "tasks", operation: "INSERT", newData: otherTeam, deliver: false},
+		{name: "unfiltered update", subscriptions: subscriptionsOf(unfiltered), table: "tasks", operation: "UPDATE", newData: otherTeam, oldData: inTeam, deliver: true, wantOperation: "UPDATE"},
+		{name: "insert in the filter", subscriptions: subscriptionsOf(filtered), table: "tasks", operation: "INSERT", newData: inTeam, deliver: true, wantOperation: "INSERT"},
+		{name: "insert outside the filter", subscriptions: subscriptionsOf(filtered), table: "tasks", operation: "INSERT", newData: otherTeam, deliver: false},
+		{name: "delete in the filter", subscriptions: subscriptionsOf(filtered), table: "tasks", operation: "DELETE", oldData: inTeam, deliver: true, wantOperation: "DELETE"},
+		{name: "delete outside the filter", subscriptions: subscriptionsOf(filtered), table: "tasks", operation: "DELETE", oldData: otherTeam, deliver: false},
+		{name: "update staying in the filter", subscriptions: subscriptionsOf(filtered), table: "tasks", operation: "UPDATE", newData: inTeam, oldData: inTeam, deliver: true, wantOperation: "UPDATE"},
+		{name: "update entering the filter", subscriptions: subscriptionsOf(filtered), table: "tasks", operation: "UPDATE", newData: inTeam, oldData: otherTeam, deliver: true, wantOperation: "UPDATE"},
+		{name: "update leaving the filter", subscriptions: subscriptionsOf(filtered), table: "tasks", operation: "UPDATE", newData: otherTeam, oldData: inTeam, deliver: true, wantOperation: "LEAVE"},
+		{name: "update outside the filter", subscriptions: subscriptionsOf(filtered), table: "tasks", operation: "UPDATE", newData: otherTeam, oldData: otherTeam, deliver: false},
+		{name: "update without the old row", subscriptions: subscriptionsOf(filtered), table: "tasks", operation: "UPDATE", newData: otherTeam, deliver: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := PublicationMessage{Type: "database", Table: tt.table, Operation: tt.operation}
+			if tt.newData != nil {
+				message.NewData = tt.newData
+			}
+			if tt.oldData != nil {
+				message.OldData = tt.oldData
+			}
+
+			routed, deliver := tt.subscriptions.routeMessage(message, tt.newData, tt.oldData)
+			if deliver != tt.deliver {
+				t.Fatalf("routeMessage() deliver = %v, want %v", deliver, tt.deliver)
+			}
+			if !deliver {
+				return
+			}
+			if routed.Operation != tt.wantOperation {
+				t.Errorf("routeMessage() operation = %s, want %s", routed.Operation, tt.wantOperation)
+			}
+			if routed.Operation == "LEAVE" && (routed.NewData != nil || routed.OldData == nil) {
+				t.Errorf("LEAVE carries new data %v and old data %v, want only the old row", routed.NewData, routed.OldData)
+			}
+		})
+	}
+}
+
+func TestLeaveMessage(t *testing.T) {
+	message := PublicationMessage{
+		Type:      "database",
+		Table:     "tasks",
+		Operation: "UPDATE",
+		NewData:   map[string]interface{}{"id": 42, "name": "Boiler", "team_id": 8},
+		OldData:   map[string]interface{}{"id": 42, "name": "Boiler", "team_id": 7},
+		Sequence:  9,
+	}
+
+	leave := leaveMessage(message, "left your filter")
+	if leave.Operation != "LEAVE" || leave.NewData != nil || leave.Sequence != 9 {
+		t.Errorf("leaveMessage() = %s new %v seq %d, want LEAVE without new data, seq 9", leave.Operation, leave.NewData, leave.Sequence)
+	}
+	if want := "tasks row 'Boiler' left your filter"; leave.Message != want {
+		t.Errorf("leaveMessage() message = %q, want %q", leave.Message, want)
+	}
+	if message.Operation != "UPDATE" || message.NewData == nil {
+		t.Error("leaveMessage() modified the original message")
+	}
+}
+
+// subscriptionsOf builds a session's subscriptions from table subscriptions
+func subscriptionsOf(tables ...*TableSubscription) *SessionSubscriptions {
+	subscriptions := &SessionSubscriptions{Tables: make(map[string]*TableSubscription)}
+	for _, table := range tables {
+		subscriptions.Tables[table.Table] = table
+	}
+	return subscriptions
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwtClockSkew tolerates small clock differences when checking exp and nbf
+const jwtClockSkew = 30 * time.Second
+
+// jwtAuthenticator validates JSON Web Tokens signed with HS256 (JWT_SECRET) or RS256 (keys of
+// the local JWKS file JWT_JWKS_FILE). No database lookup is needed.
+type jwtAuthenticator struct {
+	keys        map[string]*rsa.PublicKey // kid -> RS256 verification key
+	keysModTime time.Time
+	mutex       sync.Mutex
+}
+
+func (a *jwtAuthenticator) Name() string { return "jwt" }
+
+// Authenticate verifies the signature and claims of a JWT (implements Authenticator)
+func (a *jwtAuthenticator) Authenticate(tenant TenantDB, db *sql.DB, bearerToken string) (*AuthenticatedSession, error) {
+	parts := strings.Split(bearerToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("not a JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if err := a.verifySignature(header.Alg, header.Kid, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %w", err)
+	}
+	expiresAt, err := validateJWTClaims(claims, tenant.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := jwtIntClaim(claims, config.JWTUserClaim)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthenticatedSession{
+		TenantName: tenant.Name,
+		UserID:     userID,
+		Abilities:  jwtAbilities(claims[config.JWTAbilitiesClaim]),
+		ExpiresAt:  &expiresAt,
+		LastUsedAt: time.Now(),
+	}, nil
+}
+
+// verifySignature checks the signature of the signed part for the algorithm of the header.
+// Each algorithm only accepts its own key type, so an RS256 key can never verify an HS256 token.
+func (a *jwtAuthenticator) verifySignature(alg, kid, signed string, signature []byte) error {
+	switch alg {
+	case "HS256":
+		if config.JWTSecret == "" {
+			return fmt.Errorf("HS256 tokens are not accepted (JWT_SECRET not set)")
+		}
+		mac := hmac.New(sha256.New, []byte(config.JWTSecret))
+		mac.Write([]byte(signed))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	case "RS256":
+		key, err := a.publicKey(kid)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm: %q", alg)
+}
+
+// publicKey returns the RS256 key of a kid, reloading the JWKS file when it changed
+func (a *jwtAuthenticator) publicKey(kid string) (*rsa.PublicKey, error) {
+	if config.JWTJWKSFile == "" {
+		return nil, fmt.Errorf("RS256 tokens are not accepted (JWT_JWKS_FILE not set)")
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	info, err := os.Stat(config.JWTJWKSFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read JWKS file: %w", err)
+	}
+	if a.keys == nil || !info.ModTime().Equal(a.keysModTime) {
+		keys, err := loadJWKS(config.JWTJWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = keys
+		a.keysModTime = info.ModTime()
+	}
+
+	if key, exists := a.keys[kid]; exists {
+		return key, nil
+	}
+	// Tokens without a kid are accepted when the set holds a single key
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id: %q", kid)
+}
+
+// loadJWKS reads the RSA signing keys of a JSON Web Key Set file
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read JWKS file: %w", err)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS file: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		modulus, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of key %q: %w", key.Kid, err)
+		}
+		exponent, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent of key %q: %w", key.Kid, err)
+		}
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(modulus),
+			E: int(new(big.Int).SetBytes(exponent).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no RSA signing keys in %s", path)
+	}
+	return keys, nil
+}
+
+// decodeJWTSegment decodes a base64url JSON segment, keeping numbers as json.Number
+func decodeJWTSegment(segment string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(target)
+}
+
+// validateJWTClaims checks exp (required), nbf, iss, aud and the tenant claim. Returns the expiry.
+func validateJWTClaims(claims map[string]interface{}, tenantName string) (time.Time, error) {
+	now := time.Now()
+
+	exp, err := jwtIntClaim(claims, "exp")
+	if err != nil {
+		return time.Time{}, err
+	}
+	expiresAt := time.Unix(int64(exp), 0)
+	if now.After(expiresAt.Add(jwtClockSkew)) {
+		return time.Time{}, fmt.Errorf("token expired")
+	}
+	if _, exists := claims["nbf"]; exists {
+		nbf, err := jwtIntClaim(claims, "nbf")
+		if err != nil {
+			return time.Time{}, err
+		}
+		if now.Add(jwtClockSkew).Before(time.Unix(int64(nbf), 0)) {
+			return time.Time{}, fmt.Errorf("token not valid yet")
+		}
+	}
+
+	if config.JWTIssuer != "" && claims["iss"] != config.JWTIssuer {
+		return time.Time{}, fmt.Errorf("unexpected issuer: %v", claims["iss"])
+	}
+	if config.JWTAudience != "" && !jwtHasAudience(claims["aud"], config.JWTAudience) {
+		return time.Time{}, fmt.Errorf("token not issued for audience %s", config.JWTAudience)
+	}
+	// Tokens are bound to their tenant, so one tenant's token cannot open another's data
+	if config.JWTTenantClaim != "" && claims[config.JWTTenantClaim] != tenantName {
+		return time.Time{}, fmt.Errorf("token not issued for tenant %s", tenantName)
+	}
+	return expiresAt, nil
+}
+
+// jwtIntClaim reads an integer claim given as JSON number or numeric string
+func jwtIntClaim(claims map[string]interface{}, name string) (int, error) {
+	switch value := claims[name].(type) {
+	case json.Number:
+		parsed, err := strconv.ParseInt(value.String(), 10, 64)
+		if err == nil {
+			return int(parsed), nil
+		}
+		// exp and nbf may carry fractions of a second
+		fractional, err := value.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s claim: %w", name, err)
+		}
+		return int(fractional), nil
+	case string:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s claim: %w", name, err)
+		}
+		return parsed, nil
+	case nil:
+		return 0, fmt.Errorf("missing %s claim", name)
+	}
+	return 0, fmt.Errorf("invalid %s claim", name)
+}
+
+// jwtHasAudience checks the aud claim, a string or an array of strings
+func jwtHasAudience(aud interface{}, audience string) bool {
+	switch value := aud.(type) {
+	case string:
+		return value == audience
+	case []interface{}:
+		for _, item := range value {
+			if item == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwtAbilities reads the abilities claim: an array of strings or a space-separated string (like OAuth scope)
+func jwtAbilities(claim interface{}) []string {
+	abilities := []string{}
+	switch value := claim.(type) {
+	case string:
+		abilities = append(abilities, strings.Fields(value)...)
+	case []interface{}:
+		for _, item := range value {
+			if ability, ok := item.(string); ok {
+				abilities = append(abilities, ability)
+			}
+		}
+	}
+	return abilities
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testJWTSecret = "test-secret-with-enough-entropy"
+
+// withJWTConfig accepts HS256 tokens signed with testJWTSecret and RS256 tokens of a JWKS file
+// holding key under kid "k1"; the previous config is restored when the test ends
+func withJWTConfig(t *testing.T, key *rsa.PublicKey) {
+	t.Helper()
+	saved := config
+	t.Cleanup(func() { config = saved })
+
+	set := map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "k1",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config.JWTSecret = testJWTSecret
+	config.JWTJWKSFile = path
+	config.JWTIssuer = "https://auth.example.com"
+	config.JWTAudience = "realtime"
+	config.JWTUserClaim = "sub"
+	config.JWTAbilitiesClaim = "abilities"
+	config.JWTTenantClaim = "tenant"
+}
+
+// signJWT builds a token from a header and claims, signed by sign (nil leaves the signature empty)
+func signJWT(t *testing.T, header, claims map[string]interface{}, sign func(signed string) []byte) string {
+	t.Helper()
+	encode := func(value map[string]interface{}) string {
+		data, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+	var signature []byte
+	if sign != nil {
+		signature = sign(signed)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func hs256(secret []byte) func(string) []byte {
+	return func(signed string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		return mac.Sum(nil)
+	}
+}
+
+func rs256(t *testing.T, key *rsa.PrivateKey) func(string) []byte {
+	return func(signed string) []byte {
+		digest := sha256.Sum256([]byte(signed))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		values := map[string]interface{}{
+			"sub":       "42",
+			"exp":       time.Now().Add(time.Hour).Unix(),
+			"iss":       "https://auth.example.com",
+			"aud":       []string{"api", "realtime"},
+			"tenant":    "acme",
+			"abilities": []string{"read", "write"},
+		}
+		for name, value := range overrides {
+			if value == nil {
+				delete(values, name)
+			} else {
+				values[name] = value
+			}
+		}
+		return values
+	}
+	hsHeader := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	rsHeader := map[string]interface{}{"alg": "RS256", "typ": "JWT", "kid": "k1"}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "HS256", token: signJWT(t, hsHeader, claims(nil), hs256([]byte(testJWTSecret)))},
+		{name: "RS256", token: signJWT(t, rsHeader, claims(nil), rs256(t, key))},
+		{name: "RS256 without kid, single key", token: signJWT(t, map[string]interface{}{"alg": "RS256"}, claims(nil), rs256(t, key))},
+		{name: "HS256 with another secret", token: signJWT(t, hsHeader, claims(nil), hs256([]byte("guessed"))), wantErr: true},
+		{name: "RS256 signed by another key", token: signJWT(t, rsHeader, claims(nil), rs256(t, otherKey)), wantErr: true},
+		{name: "RS256 with an unknown kid", token: signJWT(t, map[string]interface{}{"alg": "RS256", "kid": "k2"}, claims(nil), rs256(t, key)), wantErr: true},
+		{name: "alg confusion: HS256 signed with the RSA public key", token: signJWT(t, hsHeader, claims(nil), hs256(publicPEM)), wantErr: true},
+		{name: "alg confusion: HS256 signed with the RSA public key DER", token: signJWT(t, hsHeader, claims(nil), hs256(publicDER)), wantErr: true},
+		{name: "alg none", token: signJWT(t, map[string]interface{}{"alg": "none"}, claims(nil), nil), wantErr: true},
+		{name: "alg None", token: signJWT(t, map[string]interface{}{"alg": "None"}, claims(nil), nil), wantErr: true},
+		{name: "alg HS512", token: signJWT(t, map[string]interface{}{"alg": "HS512"}, claims(nil), hs256([]byte(testJWTSecret))), wantErr: true},
+		{name: "tampered claims", token: func() string {
+			token := signJWT(t, hsHeader, claims(nil), hs256([]byte(testJWTSecret)))
+			forged := signJWT(t, hsHeader, claims(map[string]interface{}{"sub": "1"}), nil)
+			parts, forgedParts := strings.Split(token, "."), strings.Split(forged, ".")
+			return parts[0] + "." + forgedParts[1] + "." + parts[2]
+		}(), wantErr: true},
+		{name: "expired", token: signJWT(t, hsHeader, claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}), hs256([]byte(testJWTSecret))), wantErr: true},
+		{name: "other tenant", token: signJWT(t, hsHeader, claims(map[string]interface{}{"tenant": "globex"}), hs256([]byte(testJWTSecret))), wantErr: true},
+		{name: "missing user", token: signJWT(t, hsHeader, claims(map[string]interface{}{"sub": nil}), hs256([]byte(testJWTSecret))), wantErr: true},
+		{name: "not a JWT", token: "opaque-token", wantErr: true},
+		{name: "invalid signature encoding", token: signJWT(t, hsHeader, claims(nil), nil) + "!", wantErr: true},
+	}
+
+	withJWTConfig(t, &key.PublicKey)
+	authenticator := &jwtAuthenticator{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := authenticator.Authenticate(TenantDB{Name: "acme"}, nil, tt.token)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Authenticate() = %+v, want an error", session)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if session.TenantName != "acme" || session.UserID != 42 || !slices.Equal(session.Abilities, []string{"read", "write"}) {
+				t.Errorf("Authenticate() = tenant %s, user %d, abilities %v", session.TenantName, session.UserID, session.Abilities)
+			}
+			if session.ExpiresAt == nil || session.ExpiresAt.Before(time.Now()) {
+				t.Errorf("Authenticate() expiry = %v, want the exp claim", session.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestVerifySignatureWithoutKeys(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.JWTSecret = ""
+	config.JWTJWKSFile = ""
+
+	authenticator := &jwtAuthenticator{}
+	for _, alg := range []string{"HS256", "RS256", "none", ""} {
+		if err := authenticator.verifySignature(alg, "", "a.b", nil); err == nil {
+			t.Errorf("verifySignature(%q) accepted a token without configured keys", alg)
+		}
+	}
+	// An empty HMAC secret must never verify, even against the signature of an empty key
+	if err := authenticator.verifySignature("HS256", "", "a.b", hs256(nil)("a.b")); err == nil {
+		t.Error("verifySignature() accepted a token signed with an empty secret")
+	}
+}
+
+func TestValidateJWTClaims(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved })
+
+	now := time.Now().Unix()
+	tests := []struct {
+		name     string
+		issuer   string
+		audience string
+		tenant   string
+		claims   map[string]interface{}
+		wantErr  bool
+	}{
+		{name: "exp only", claims: map[string]interface{}{"exp": json.Number("9999999999")}},
+		{name: "missing exp", claims: map[string]interface{}{}, wantErr: true},
+		{name: "fractional exp", claims: map[string]interface{}{"exp": json.Number("9999999999.5")}},
+		{name: "exp as string", claims: map[string]interface{}{"exp": "9999999999"}},
+		{name: "exp as bool", claims: map[string]interface{}{"exp": true}, wantErr: true},
+		{name: "expired", claims: map[string]interface{}{"exp": jsonInt(now - 60)}, wantErr: true},
+		{name: "expired within the clock skew", claims: map[string]interface{}{"exp": jsonInt(now - 10)}},
+		{name: "not valid yet", claims: map[string]interface{}{"exp": jsonInt(now + 3600), "nbf": jsonInt(now + 600)}, wantErr: true},
+		{name: "nbf within the clock skew", claims: map[string]interface{}{"exp": jsonInt(now + 3600), "nbf": jsonInt(now + 10)}},
+		{name: "invalid nbf", claims: map[string]interface{}{"exp": jsonInt(now + 3600), "nbf": "soon"}, wantErr: true},
+		{name: "issuer", issuer: "https://auth.example.com", claims: map[string]interface{}{"exp": jsonInt(now + 3600), "iss": "https://auth.example.com"}},
+		{name: "wrong issuer", issuer: "https://auth.example.com", claims: map[string]interface{}{"exp": jsonInt(now + 3600), "iss": "https://evil.example.com"}, wantErr: true},
+		{name: "missing issuer", issuer: "https://auth.example.com", claims: map[string]interface{}{"exp": jsonInt(now + 3600)}, wantErr: true},
+		{name: "audience string", audience: "realtime", claims: map[string]interface{}{"exp": jsonInt(now + 3600), "aud": "realtime"}},
+		{name: "audience list", audience: "realtime", claims: map[string]interface{}{"exp": jsonInt(now + 3600), "aud": []interface{}{"api", "realtime"}}},
+		{name: "wrong audience", audience: "realtime", claims: map[string]interface{}{"exp": jsonInt(now + 3600), "aud": []interface{}{"api"}}, wantErr: true},
+		{name: "tenant", tenant: "tenant", claims: map[string]interface{}{"exp": jsonInt(now + 3600), "tenant": "acme"}},
+		{name: "other tenant", tenant: "tenant", claims: map[string]interface{}{"exp": jsonInt(now + 3600), "tenant": "globex"}, wantErr: true},
+		{name: "missing tenant", tenant: "tenant", claims: map[string]interface{}{"exp": jsonInt(now + 3600)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.JWTIssuer = tt.issuer
+			config.JWTAudience = tt.audience
+			config.JWTTenantClaim = tt.tenant
+
+			_, err := validateJWTClaims(tt.claims, "acme")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateJWTClaims() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func jsonInt(value int64) json.Number {
+	return json.Number(strconv.FormatInt(value, 10))
+}
+
+func TestJWTAbilities(t *testing.T) {
+	tests := []struct {
+		name  string
+		claim interface{}
+		want  []string
+	}{
+		{name: "array", claim: []interface{}{"read", "write"}, want: []string{"read", "write"}},
+		{name: "scope string", claim: "read  write", want: []string{"read", "write"}},
+		{name: "non-string items are skipped", claim: []interface{}{"read", json.Number("1")}, want: []string{"read"}},
+		{name: "missing", claim: nil, want: []string{}},
+		{name: "object", claim: map[string]interface{}{"read": true}, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jwtAbilities(tt.claim); !slices.Equal(got, tt.want) {
+				t.Errorf("jwtAbilities(%v) = %v, want %v", tt.claim, got, tt.want)
+			}
+		})
+	}
+}
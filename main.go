@@ -9,19 +9,36 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/igm/sockjs-go/v3/sockjs"
-	_ "github.com/lib/pq"
 	"github.com/suisseworks/whagonsRLE/routes"
 )
 
 func main() {
+	initConfig()
+
 	engine := &RealtimeEngine{
 		tenantDBs:             make(map[string]*sql.DB),
 		sessions:              make(map[string]sockjs.Session),
 		negotiationSessions:   make(map[string]sockjs.Session),
 		authenticatedSessions: make(map[string]*AuthenticatedSession),
 		tokenCache:            make(map[string]*CachedToken),
+		rowDecoders:           make(map[string]RowDecoder),
+		rowAuthorizers:        make(map[string]RowAuthorizer),
+		tenantListeners:       make(map[string]*tenantListener),
+		authenticators:        make(map[string]Authenticator),
+		changeSources:         make(map[string]ChangeSource),
+		subscriptions:         make(map[string]*SessionSubscriptions),
+		streams:               make(map[string]*TenantStream),
+		rowHashTables:         make(map[string]map[string]bool),
+		batchers:              make(map[string]*changeBatcher),
+		outboundQueues:        make(map[string]*outboundQueue),
+		tenantSessions:        make(map[string]*tenantSessions),
 	}
 
+	// Register typed decoders for tables with a known record shape
+	engine.registerDefaultRowDecoders()
+	engine.registerRowPolicies()
+	engine.registerDefaultAuthenticators()
+
 	// Connect to landlord database
 	if err := engine.connectToLandlord(); err != nil {
 		log.Printf("⚠️  Failed to connect to landlord database: %v", err)
@@ -52,6 +69,17 @@ func main() {
 		}
 	}()
 
+	// Start live session token revalidation routine
+	if config.TokenRevalidateSeconds > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Duration(config.TokenRevalidateSeconds) * time.Second)
+			defer ticker.Stop()
+			for range ticker.C {
+				engine.revalidateSessions()
+			}
+		}()
+	}
+
 	// Start zombie session cleanup routine
 	go func() {
 		ticker := time.NewTicker(30 * time.Second) // Clean up every 30 seconds
@@ -73,6 +101,7 @@ func main() {
 	})
 
 	// Setup API routes with controllers
+	logAdminKeys()
 	routes.SetupRoutes(app, engine)
 
 	// SockJS handler with custom options for CORS
@@ -117,6 +146,7 @@ func main() {
 	log.Printf("   POST /api/tenants/reload - Reload and connect to new tenants")
 	log.Printf("   POST /api/tenants/test-notification - Test tenant notification system")
 	log.Printf("   POST /api/broadcast - Broadcast message to all sessions")
+	log.Printf("   POST /api/checksum - Compute a table checksum (bearer token + tenant domain)")
 
 	// Start HTTP server with Fiber
 	log.Fatal(app.Listen(":" + config.ServerPort))
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/igm/sockjs-go/v3/sockjs"
+	"github.com/lib/pq"
+)
+
+// notificationChannel receives the ids and recipients of new user notifications
+const notificationChannel = "whagons_notifications"
+
+// notificationTriggerName is the trigger installed on the notifications table
+const notificationTriggerName = "whagons_notification_trigger"
+
+// defaultNotificationLimit is the number of unread notifications list_notifications returns by default
+const defaultNotificationLimit = 50
+
+// notificationSQL installs a trigger announcing every new row of the notifications table. Only the
+// id and recipient are sent; the row is read back, so large notification data never hits the
+// pg_notify payload limit.
+func notificationSQL(table string) []string {
+	return []string{
+		fmt.Sprintf(`
+		CREATE OR REPLACE FUNCTION whagons_notify_notification()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			PERFORM pg_notify('%s', json_build_object(
+				'id', NEW.id,
+				'notifiable_type', NEW.notifiable_type,
+				'notifiable_id', NEW.notifiable_id
+			)::text);
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;`, notificationChannel),
+		fmt.Sprintf(`DROP TRIGGER IF EXISTS %s ON %s;`, notificationTriggerName, table),
+		fmt.Sprintf(`CREATE TRIGGER %s
+		AFTER INSERT ON %s
+		FOR EACH ROW
+		EXECUTE FUNCTION whagons_notify_notification();`, notificationTriggerName, table),
+	}
+}
+
+// notificationPayload is what the trigger sends for a new notification
+type notificationPayload struct {
+	ID             string `json:"id"`
+	NotifiableType string `json:"notifiable_type"`
+	NotifiableID   int    `json:"notifiable_id"`
+}
+
+// setupNotifications installs the notification trigger in a tenant database and listens to it, so
+// each new notification is delivered to its recipient's sessions
+func (e *RealtimeEngine) setupNotifications(tenant TenantDB, db *sql.DB) error {
+	if !config.Notifications {
+		return nil
+	}
+	if !identifierPattern.MatchString(config.NotificationsTable) {
+		return fmt.Errorf("invalid notifications table: %q", config.NotificationsTable)
+	}
+
+	var exists bool
+	if err := db.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, config.NotificationsTable).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		log.Printf("ℹ️  Tenant %s has no %s table - user notifications disabled", tenant.Name, config.NotificationsTable)
+		return nil
+	}
+
+	statements := notificationSQL(config.NotificationsTable)
+	if config.TriggerDryRun {
+		log.Printf("📝 [dry-run] Notification trigger for tenant %s (database: %s):\n%s",
+			tenant.Name, tenant.Database, strings.Join(statements, "\n"))
+	} else {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("failed to install notification trigger: %w", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	// After a reconnect clients catch up on missed notifications with list_notifications
+	return e.listenTenantChannel(tenant, notificationChannel, channelHandler{
+		notify: func(message string) {
+			var payload notificationPayload
+			if err := json.Unmarshal([]byte(message), &payload); err != nil {
+				log.Printf("⚠️  Invalid notification payload for %s: %v", tenant.Name, err)
+				return
+			}
+			if payload.NotifiableType != config.NotificationsNotifiableType {
+				return
+			}
+			e.deliverNotification(tenant.Name, payload)
+		},
+	})
+}
+
+// deliverNotification sends a new notification to the active sessions of its recipient only
+func (e *RealtimeEngine) deliverNotification(tenantName string, payload notificationPayload) {
+	entries := e.userSessionList(tenantName, payload.NotifiableID)
+	if len(entries) == 0 {
+		return
+	}
+
+	db, err := e.tenantDB(tenantName)
+	if err != nil {
+		log.Printf("❌ Failed to deliver notification %s: %v", payload.ID, err)
+		return
+	}
+	notifications, err := queryNotifications(db,
+		fmt.Sprintf(`SELECT id, type, data, read_at, created_at FROM %s WHERE id::text = $1`, config.NotificationsTable),
+		payload.ID)
+	if err != nil {
+		log.Printf("❌ Failed to read notification %s of tenant %s: %v", payload.ID, tenantName, err)
+		return
+	}
+	if len(notifications) == 0 {
+		// Deleted (or rolled back) before it could be read
+		return
+	}
+
+	// Marshal once, the sessionId is added for each session
+	message, err := json.Marshal(SystemMessage{
+		Type:      "notification",
+		Operation: "created",
+		Message:   notifications[0].Type,
+		Data:      notifications[0],
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Printf("❌ Failed to marshal notification %s: %v", payload.ID, err)
+		return
+	}
+
+	delivered := 0
+	for _, entry := range entries {
+		if entry.outbound.enqueueMessage(message) {
+			delivered++
+		}
+	}
+	log.Printf("🔔 Notification %s delivered to %d session(s) of user %d (tenant: %s)",
+		payload.ID, delivered, payload.NotifiableID, tenantName)
+}
+
+// queryNotifications runs a query selecting id, type, data, read_at and created_at of notifications
+func queryNotifications(db *sql.DB, query string, args ...interface{}) ([]Notification, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var notification Notification
+		var data sql.NullString
+		var readAt, createdAt sql.NullTime
+		if err := rows.Scan(&notification.ID, &notification.Type, &data, &readAt, &createdAt); err != nil {
+			return nil, err
+		}
+		// Laravel stores the data as JSON text; anything else is passed on as a string
+		if data.Valid && json.Valid([]byte(data.String)) {
+			notification.Data = json.RawMessage(data.String)
+		} else if data.Valid {
+			notification.Data, _ = json.Marshal(data.String)
+		}
+		if readAt.Valid {
+			notification.ReadAt = &readAt.Time
+		}
+		if createdAt.Valid {
+			notification.CreatedAt = &createdAt.Time
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+// handleListNotifications sends the user's unread notifications, newest first (to catch up after connecting)
+func (e *RealtimeEngine) handleListNotifications(session sockjs.Session, authSession *AuthenticatedSession, command ClientCommand) error {
+	limit := command.Limit
+	if limit <= 0 || limit > defaultNotificationLimit {
+		limit = defaultNotificationLimit
+	}
+
+	db, err := e.tenantDB(authSession.TenantName)
+	if err != nil {
+		return e.sendCommandError(session, command, err.Error())
+	}
+	notifications, err := queryNotifications(db, fmt.Sprintf(`
+		SELECT id, type, data, read_at, created_at FROM %s
+		WHERE notifiable_type = $1 AND notifiable_id = $2 AND read_at IS NULL
+		ORDER BY created_at DESC
+		LIMIT $3`, config.NotificationsTable),
+		config.NotificationsNotifiableType, authSession.UserID, limit)
+	if err != nil {
+		log.Printf("❌ Failed to list notifications of user %d (tenant: %s): %v", authSession.UserID, authSession.TenantName, err)
+		return e.sendCommandError(session, command, "Failed to list notifications")
+	}
+
+	data := map[string]interface{}{"notifications": notifications}
+	if command.RequestID != "" {
+		data["request_id"] = command.RequestID
+	}
+	return e.sendToSession(session, SystemMessage{
+		Type:      "notification",
+		Operation: "list",
+		Message:   fmt.Sprintf("%d unread notification(s)", len(notifications)),
+		Data:      data,
+		Timestamp: time.Now().Format(time.RFC3339),
+		SessionId: session.ID(),
+	})
+}
+
+// handleAckNotifications marks notifications of the user as read (the given ids, or all with "all")
+// and tells the user's other sessions, so unread counts stay in sync across devices
+func (e *RealtimeEngine) handleAckNotifications(session sockjs.Session, authSession *AuthenticatedSession, command ClientCommand) error {
+	if len(command.IDs) == 0 && !command.All {
+		return e.sendCommandError(session, command, "Notification ids or \"all\" are required")
+	}
+
+	db, err := e.tenantDB(authSession.TenantName)
+	if err != nil {
+		return e.sendCommandError(session, command, err.Error())
+	}
+
+	// The recipient condition keeps users from marking the notifications of others
+	query := fmt.Sprintf(`
+		UPDATE %s SET read_at = now()
+		WHERE notifiable_type = $1 AND notifiable_id = $2 AND read_at IS NULL`, config.NotificationsTable)
+	args := []interface{}{config.NotificationsNotifiableType, authSession.UserID}
+	if !command.All {
+		query += ` AND id::text = ANY($3)`
+		args = append(args, pq.Array(command.IDs))
+	}
+
+	rows, err := db.Query(query+` RETURNING id::text`, args...)
+	if err != nil {
+		log.Printf("❌ Failed to mark notifications of user %d as read (tenant: %s): %v", authSession.UserID, authSession.TenantName, err)
+		return e.sendCommandError(session, command, "Failed to mark notifications as read")
+	}
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		log.Printf("❌ Failed to mark notifications of user %d as read (tenant: %s): %v", authSession.UserID, authSession.TenantName, err)
+		return e.sendCommandError(session, command, "Failed to mark notifications as read")
+	}
+
+	log.Printf("📭 User %d read %d notification(s) (tenant: %s)", authSession.UserID, len(ids), authSession.TenantName)
+
+	if len(ids) > 0 {
+		// The user's other sessions learn about it through their send queues
+		message, err := json.Marshal(SystemMessage{
+			Type:      "notification",
+			Operation: "read",
+			Message:   fmt.Sprintf("%d notification(s) read", len(ids)),
+			Data:      map[string]interface{}{"ids": ids},
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+		if err != nil {
+			log.Printf("❌ Failed to marshal notification read message: %v", err)
+		} else {
+			for _, entry := range e.userSessionList(authSession.TenantName, authSession.UserID) {
+				if entry.id != session.ID() {
+					entry.outbound.enqueueMessage(message)
+				}
+			}
+		}
+	}
+
+	data := map[string]interface{}{"ids": ids}
+	if command.RequestID != "" {
+		data["request_id"] = command.RequestID
+	}
+	return e.sendToSession(session, SystemMessage{
+		Type:      "notification",
+		Operation: "acked",
+		Message:   fmt.Sprintf("%d notification(s) marked as read", len(ids)),
+		Data:      data,
+		Timestamp: time.Now().Format(time.RFC3339),
+		SessionId: session.ID(),
+	})
+}
@@ -0,0 +1,649 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/igm/sockjs-go/v3/sockjs"
+)
+
+// slowConsumerCloseCode closes sessions evicted for not keeping up with their send queue
+const slowConsumerCloseCode = 4008
+
+// outboundStats counts the work of all outbound queues since startup
+type outboundStats struct {
+	enqueued  atomic.Int64 // changes queued
+	sent      atomic.Int64 // changes sent
+	frames    atomic.Int64 // SockJS messages written
+	coalesced atomic.Int64 // updates merged into an already queued update of the same row
+	dropped   atomic.Int64 // changes discarded because their session went away
+	throttled atomic.Int64 // flushes that left changes queued because of the rate cap
+	evicted   atomic.Int64 // sessions disconnected because their queue overflowed
+}
+
+// outboundChange is a queued publication along with its JSON, marshaled once per broadcast
+type outboundChange struct {
+	message PublicationMessage
+	payload json.RawMessage // marshaled with an empty SessionId, nil when it must be marshaled on send
+}
+
+// outboundItem is one queued delivery: a single change, the changes of a transaction or a system message
+type outboundItem struct {
+	changes []outboundChange
+	key     string          // coalescing key of a single UPDATE, empty when not coalescable
+	message json.RawMessage // system message sent in a frame of its own, marshaled with an empty SessionId
+	close   *sessionClose   // closes the session once the message is sent
+}
+
+// sessionClose closes a session after its last queued message
+type sessionClose struct {
+	code   uint32
+	reason string
+}
+
+// count is the number of changes an item counts for in the queue size and the rate cap
+func (item *outboundItem) count() int {
+	if item.message != nil {
+		return 1
+	}
+	return len(item.changes)
+}
+
+// outboundQueue is a session's bounded send queue. Its writer goroutine sends the publications
+// and system messages, so a slow client never holds up a broadcast. With OUTBOUND_QUEUE it also buffers them for a
+// short window, merging repeated updates of the same row, and writes them as few frames as
+// possible within the session's rate cap.
+type outboundQueue struct {
+	engine     *RealtimeEngine
+	sessionID  string
+	session    sockjs.Session
+	items      []*outboundItem
+	pending    map[string]*outboundItem // coalescing key -> queued update
+	size       int                      // queued changes
+	tokens     float64
+	lastRefill time.Time
+	wake       chan struct{}
+	stop       chan struct{}
+	done       chan struct{} // closed when the writer has stopped
+	closed     bool
+	closing    bool   // the closing message is queued, nothing may follow it
+	lastSeq    uint64 // highest sequence number queued, later copies of a change are dropped
+	takenSeq   uint64 // highest sequence number handed to the writer
+	mutex      sync.Mutex
+}
+
+// newOutboundQueue creates a queue and starts its writer
+func (e *RealtimeEngine) newOutboundQueue(sessionID string, session sockjs.Session) *outboundQueue {
+	q := &outboundQueue{
+		engine:     e,
+		sessionID:  sessionID,
+		session:    session,
+		pending:    make(map[string]*outboundItem),
+		tokens:     float64(config.OutboundMaxRate),
+		lastRefill: time.Now(),
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// removeOutboundQueueLocked closes and forgets a session's queue; the caller holds e.mutex
+func (e *RealtimeEngine) removeOutboundQueueLocked(sessionID string) {
+	if q, exists := e.outboundQueues[sessionID]; exists {
+		q.close()
+		delete(e.outboundQueues, sessionID)
+	}
+}
+
+// coalescingKey identifies the row of a change, so later updates of it can be merged
+func coalescingKey(message PublicationMessage) string {
+	values := rowValues(message.NewData)
+	if values == nil {
+		values = rowValues(message.OldData)
+	}
+	if values == nil {
+		return ""
+	}
+	key, exists := values[snapshotKeyColumn]
+	if !exists || key == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s:%v", message.Table, key)
+}
+
+// enqueue adds publications to the queue. A single UPDATE of a row that already has an update
+// waiting is merged into it and moves to the tail; any other change of the row ends coalescing for it. A queue that
+// would exceed OUTBOUND_QUEUE_SIZE evicts its session instead of growing.
+func (q *outboundQueue) enqueue(changes []outboundChange) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed || q.closing {
+		q.engine.outboundStats.dropped.Add(int64(len(changes)))
+		return
+	}
+	// A resume may already have queued these changes from the replay buffer
+	changes = q.unqueuedLocked(changes)
+	if len(changes) == 0 {
+		return
+	}
+	q.engine.outboundStats.enqueued.Add(int64(len(changes)))
+
+	key := ""
+	if config.OutboundQueue && len(changes) == 1 && changes[0].message.Operation == "UPDATE" {
+		key = coalescingKey(changes[0].message)
+		if queued, exists := q.pending[key]; exists && key != "" {
+			// The merged update no longer matches any shared payload
+			queued.changes[0] = outboundChange{message: coalesceUpdates(queued.changes[0].message, changes[0].message)}
+			q.engine.outboundStats.coalesced.Add(1)
+
+			// It carries the newer sequence number, so it moves behind the changes queued since
+			for i, item := range q.items {
+				if item == queued {
+					q.items = append(q.items[:i], q.items[i+1:]...)
+					break
+				}
+			}
+			q.items = append(q.items, queued)
+			return
+		}
+	}
+
+	if config.OutboundQueueSize > 0 && q.size+len(changes) > config.OutboundQueueSize {
+		q.engine.outboundStats.dropped.Add(int64(len(changes)))
+		q.overflowLocked()
+		return
+	}
+
+	if key != "" {
+		item := &outboundItem{changes: changes, key: key}
+		q.items = append(q.items, item)
+		q.pending[key] = item
+		q.size++
+		q.signal()
+		return
+	}
+
+	for _, change := range changes {
+		delete(q.pending, coalescingKey(change.message))
+	}
+	q.items = append(q.items, &outboundItem{changes: changes})
+	q.size += len(changes)
+	q.signal()
+}
+
+// unqueuedLocked returns the changes newer than everything queued so far and records their
+// sequence numbers; the caller holds q.mutex
+func (q *outboundQueue) unqueuedLocked(changes []outboundChange) []outboundChange {
+	fresh := changes[:0:0]
+	for _, change := range changes {
+		if change.message.Sequence != 0 && change.message.Sequence <= q.lastSeq {
+			continue
+		}
+		fresh = append(fresh, change)
+	}
+	for _, change := range fresh {
+		q.lastSeq = max(q.lastSeq, change.message.Sequence)
+	}
+	return fresh
+}
+
+// enqueueReplay queues the changes a resuming client missed, up to sequence number last, ahead of
+// the live changes still waiting, followed by the resumed message. Waiting copies of replayed
+// changes are dropped, later copies are never queued. Returns false when the writer already took
+// a change newer than since, which the replay could only follow out of order, or when the
+// session is gone.
+func (q *outboundQueue) enqueueReplay(changes []outboundChange, since, last uint64, resumed json.RawMessage) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed || q.closing || q.takenSeq > since {
+		return false
+	}
+
+	// Live changes the replay covers leave the queue
+	waiting := make([]*outboundItem, 0, len(q.items))
+	for _, item := range q.items {
+		if item.message != nil {
+			waiting = append(waiting, item)
+			continue
+		}
+		kept := item.changes[:0:0]
+		for _, change := range item.changes {
+			if change.message.Sequence == 0 || change.message.Sequence > last {
+				kept = append(kept, change)
+			}
+		}
+		q.size -= len(item.changes) - len(kept)
+		if len(kept) == 0 {
+			if item.key != "" && q.pending[item.key] == item {
+				delete(q.pending, item.key)
+			}
+			continue
+		}
+		item.changes = kept
+		waiting = append(waiting, item)
+	}
+
+	replay := make([]*outboundItem, 0, len(changes)+1+len(waiting))
+	for _, change := range changes {
+		replay = append(replay, &outboundItem{changes: []outboundChange{change}})
+	}
+	replay = append(replay, &outboundItem{message: resumed})
+	q.items = append(replay, waiting...)
+	q.size += len(changes) + 1
+	q.lastSeq = max(q.lastSeq, last)
+	q.engine.outboundStats.enqueued.Add(int64(len(changes) + 1))
+
+	if config.OutboundQueueSize > 0 && q.size > config.OutboundQueueSize {
+		q.overflowLocked()
+		return true
+	}
+	q.signal()
+	return true
+}
+
+// enqueueMessage queues a system message marshaled with an empty SessionId. It is never merged and
+// goes out in a frame of its own, after the publications queued before it. Returns false when the
+// session is gone or its queue overflowed.
+func (q *outboundQueue) enqueueMessage(payload json.RawMessage) bool {
+	return q.enqueueItem(&outboundItem{message: payload})
+}
+
+// enqueueJSON marshals a message whose sessionId is empty and its last field, and queues it
+func (q *outboundQueue) enqueueJSON(message interface{}) bool {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("❌ Failed to marshal message for session %s: %v", q.sessionID, err)
+		return false
+	}
+	return q.enqueueMessage(payload)
+}
+
+// enqueueClose queues a last system message, after which the writer closes the session
+func (q *outboundQueue) enqueueClose(payload json.RawMessage, code uint32, reason string) bool {
+	return q.enqueueItem(&outboundItem{message: payload, close: &sessionClose{code: code, reason: reason}})
+}
+
+// enqueueItem adds a system message item to the queue
+func (q *outboundQueue) enqueueItem(item *outboundItem) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed || q.closing {
+		q.engine.outboundStats.dropped.Add(1)
+		return false
+	}
+	q.engine.outboundStats.enqueued.Add(1)
+
+	if config.OutboundQueueSize > 0 && q.size+1 > config.OutboundQueueSize {
+		q.engine.outboundStats.dropped.Add(1)
+		q.overflowLocked()
+		return false
+	}
+
+	q.items = append(q.items, item)
+	q.size++
+	q.closing = item.close != nil
+	q.signal()
+	return true
+}
+
+// coalesceUpdates merges a newer update of a row into a queued one. The result keeps the
+// queued old row (the state the client still has) and the newest values and sequence number.
+func coalesceUpdates(queued, newer PublicationMessage) PublicationMessage {
+	merged := newer
+	if newer.Encoding != "delta" {
+		merged.OldData = queued.OldData
+		return merged
+	}
+
+	// Delta on top of a queued update: overlay the newer changed columns
+	values := make(map[string]interface{})
+	for column, value := range rowValues(queued.NewData) {
+		values[column] = value
+	}
+	for column, value := range rowValues(newer.NewData) {
+		values[column] = value
+	}
+	merged.NewData = values
+
+	if queued.Encoding == "delta" {
+		columns := make(map[string]bool)
+		for _, column := range queued.ChangedColumns {
+			columns[column] = true
+		}
+		for _, column := range newer.ChangedColumns {
+			columns[column] = true
+		}
+		merged.ChangedColumns = make([]string, 0, len(columns))
+		for column := range columns {
+			merged.ChangedColumns = append(merged.ChangedColumns, column)
+		}
+		sort.Strings(merged.ChangedColumns)
+	} else {
+		// The queued update was a full row, so the merged one is as well
+		merged.Encoding = ""
+		merged.ChangedColumns = nil
+		merged.OldData = queued.OldData
+	}
+	return merged
+}
+
+// signal wakes the writer without blocking
+func (q *outboundQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// overflowLocked discards the queue of a client that cannot keep up and evicts its session;
+// the caller holds q.mutex
+func (q *outboundQueue) overflowLocked() {
+	dropped := q.size
+	q.closed = true
+	q.engine.outboundStats.dropped.Add(int64(dropped))
+	q.items = nil
+	q.pending = nil
+	q.size = 0
+	close(q.stop)
+
+	// Eviction takes e.mutex and writes to the session, so it must not run under q.mutex
+	go q.engine.evictSlowSession(q.sessionID, q.session, dropped)
+}
+
+// run waits for queued changes, lets the coalescing window pass and flushes until the queue is
+// empty. While the rate cap holds changes back it waits for the cap to allow the next one.
+func (q *outboundQueue) run() {
+	defer close(q.done)
+
+	var window time.Duration
+	if config.OutboundQueue {
+		window = time.Duration(config.OutboundWindowMs) * time.Millisecond
+	}
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		}
+
+		delay := window
+		for {
+			if delay > 0 {
+				select {
+				case <-q.stop:
+					return
+				case <-time.After(delay):
+				}
+			}
+			if !q.flush() {
+				break
+			}
+			delay = max(window, rateDelay())
+		}
+	}
+}
+
+// rateDelay is the time OUTBOUND_MAX_RATE takes to allow one more change
+func rateDelay() time.Duration {
+	if config.OutboundMaxRate <= 0 {
+		return 0
+	}
+	return time.Second / time.Duration(config.OutboundMaxRate)
+}
+
+// take removes the deliveries the rate cap allows from the head of the queue
+func (q *outboundQueue) take() ([]*outboundItem, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	budget := q.size
+	shaped := config.OutboundQueue && config.OutboundMaxRate > 0
+	if shaped {
+		now := time.Now()
+		q.tokens += now.Sub(q.lastRefill).Seconds() * float64(config.OutboundMaxRate)
+		if q.tokens > float64(config.OutboundMaxRate) {
+			q.tokens = float64(config.OutboundMaxRate)
+		}
+		q.lastRefill = now
+		budget = int(q.tokens)
+	}
+
+	var items []*outboundItem
+	count := 0
+	for len(items) < len(q.items) && q.items[len(items)].count() <= budget-count {
+		item := q.items[len(items)]
+		if item.key != "" && q.pending[item.key] == item {
+			delete(q.pending, item.key)
+		}
+		items = append(items, item)
+		count += item.count()
+	}
+
+	// A transaction larger than a full second of budget still goes out whole
+	if len(items) == 0 && len(q.items) > 0 && shaped && q.tokens >= float64(config.OutboundMaxRate) {
+		items = q.items[:1]
+		count = items[0].count()
+	}
+	q.items = q.items[len(items):]
+	q.size -= count
+	for _, item := range items {
+		for _, change := range item.changes {
+			q.takenSeq = max(q.takenSeq, change.message.Sequence)
+		}
+	}
+
+	if shaped {
+		q.tokens -= float64(count)
+		if len(q.items) > 0 {
+			q.engine.outboundStats.throttled.Add(1)
+		}
+	}
+	return items, len(q.items) > 0
+}
+
+// flush sends the deliveries allowed by the rate cap: with OUTBOUND_QUEUE the publications as one
+// frame, otherwise one frame per delivery as they were enqueued. System messages always go out in
+// a frame of their own, in queue order. Returns true while changes remain.
+func (q *outboundQueue) flush() bool {
+	items, remaining := q.take()
+	if !config.OutboundQueue {
+		for _, item := range items {
+			var sent bool
+			if item.message != nil {
+				sent = q.sendMessage(item)
+			} else {
+				sent = q.send(item.changes)
+			}
+			if !sent {
+				return false
+			}
+		}
+		return remaining
+	}
+
+	var changes []outboundChange
+	for _, item := range items {
+		if item.message == nil {
+			changes = append(changes, item.changes...)
+			continue
+		}
+		if !q.send(changes) || !q.sendMessage(item) {
+			return false
+		}
+		changes = nil
+	}
+	if !q.send(changes) {
+		return false
+	}
+	return remaining
+}
+
+// sendMessage writes a queued system message and closes the session when it was the last one.
+// Returns false when the session failed or was closed.
+func (q *outboundQueue) sendMessage(item *outboundItem) bool {
+	if err := q.session.Send(addressedFrame(item.message, q.sessionID)); err != nil {
+		log.Printf("❌ Failed to send to session %s: %v", q.sessionID, err)
+		q.engine.outboundStats.dropped.Add(1)
+		q.engine.removeFailedSession(q.sessionID)
+		return false
+	}
+	q.engine.outboundStats.sent.Add(1)
+	q.engine.outboundStats.frames.Add(1)
+
+	if item.close != nil {
+		q.session.Close(item.close.code, item.close.reason)
+		q.close()
+		return false
+	}
+	return true
+}
+
+// send writes publications as one frame: a plain message for one change, a batch otherwise.
+// Payloads shared with other sessions are reused, only the sessionId is added per session.
+// Returns false when the session failed and was removed.
+func (q *outboundQueue) send(changes []outboundChange) bool {
+	if len(changes) == 0 {
+		return true
+	}
+
+	payloads := make([]json.RawMessage, len(changes))
+	for i, change := range changes {
+		payloads[i] = change.payload
+		if payloads[i] != nil {
+			continue
+		}
+		payload, err := json.Marshal(change.message)
+		if err != nil {
+			log.Printf("❌ Failed to marshal outbound frame for session %s: %v", q.sessionID, err)
+			q.engine.outboundStats.dropped.Add(int64(len(changes)))
+			return true
+		}
+		payloads[i] = payload
+	}
+
+	var frame string
+	if len(changes) == 1 {
+		frame = addressedFrame(payloads[0], q.sessionID)
+	} else {
+		// Several deliveries share one frame; txid is only set when they are one transaction
+		first, last := changes[0].message, changes[len(changes)-1].message
+		txID := first.TxID
+		for _, change := range changes {
+			if change.message.TxID != txID {
+				txID = ""
+				break
+			}
+		}
+		batch, err := json.Marshal(BatchMessage{
+			Type:       "batch",
+			TenantName: first.TenantName,
+			TxID:       txID,
+			Changes:    payloads,
+			FirstSeq:   first.Sequence,
+			LastSeq:    last.Sequence,
+			ClientTime: time.Now().Format(time.RFC3339),
+			SessionId:  q.sessionID,
+		})
+		if err != nil {
+			log.Printf("❌ Failed to marshal outbound frame for session %s: %v", q.sessionID, err)
+			q.engine.outboundStats.dropped.Add(int64(len(changes)))
+			return true
+		}
+		frame = string(batch)
+	}
+
+	if err := q.session.Send(frame); err != nil {
+		log.Printf("❌ Failed to send to session %s: %v", q.sessionID, err)
+		q.engine.outboundStats.dropped.Add(int64(len(changes)))
+		q.engine.removeFailedSession(q.sessionID)
+		return false
+	}
+
+	q.engine.outboundStats.sent.Add(int64(len(changes)))
+	q.engine.outboundStats.frames.Add(1)
+	log.Printf("📤 Sent %d publication(s) to session %s", len(changes), q.sessionID)
+	return true
+}
+
+// close stops the writer and discards what is still queued
+func (q *outboundQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.engine.outboundStats.dropped.Add(int64(q.size))
+	q.items = nil
+	q.pending = nil
+	q.size = 0
+	close(q.stop)
+}
+
+// removeFailedSession forgets a session that can no longer be written to
+func (e *RealtimeEngine) removeFailedSession(sessionID string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.forgetSessionLocked(sessionID)
+}
+
+// evictSlowSession disconnects a session whose send queue overflowed. The client is told to
+// resync, since the changes dropped with its queue will never reach it.
+func (e *RealtimeEngine) evictSlowSession(sessionID string, session sockjs.Session, dropped int) {
+	e.outboundStats.evicted.Add(1)
+	log.Printf("🐢 Evicting slow session %s: send queue overflowed (%d queued changes dropped)", sessionID, dropped)
+
+	e.sendToSession(session, SystemMessage{
+		Type:      "system",
+		Operation: "resync_required",
+		Message:   "Resync required: send queue overflowed",
+		Data: map[string]interface{}{
+			"reason":  "send_queue_overflow",
+			"dropped": dropped,
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+		SessionId: sessionID,
+	})
+	session.Close(slowConsumerCloseCode, "Slow consumer - resync required")
+	e.removeFailedSession(sessionID)
+}
+
+// GetOutboundStats returns the outbound queue metrics (implements HealthEngineInterface)
+func (e *RealtimeEngine) GetOutboundStats() map[string]int {
+	e.mutex.RLock()
+	queues := make([]*outboundQueue, 0, len(e.outboundQueues))
+	for _, q := range e.outboundQueues {
+		queues = append(queues, q)
+	}
+	e.mutex.RUnlock()
+
+	queued := 0
+	for _, q := range queues {
+		q.mutex.Lock()
+		queued += q.size
+		q.mutex.Unlock()
+	}
+
+	return map[string]int{
+		"queues":            len(queues),
+		"queued_changes":    queued,
+		"enqueued_changes":  int(e.outboundStats.enqueued.Load()),
+		"sent_changes":      int(e.outboundStats.sent.Load()),
+		"sent_frames":       int(e.outboundStats.frames.Load()),
+		"coalesced_updates": int(e.outboundStats.coalesced.Load()),
+		"dropped_changes":   int(e.outboundStats.dropped.Load()),
+		"throttled_flushes": int(e.outboundStats.throttled.Load()),
+		"evicted_sessions":  int(e.outboundStats.evicted.Load()),
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// withOutboundConfig sets the outbound queue settings for one test
+func withOutboundConfig(t *testing.T, queue bool, maxRate, queueSize int) {
+	t.Helper()
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.OutboundQueue = queue
+	config.OutboundMaxRate = maxRate
+	config.OutboundQueueSize = queueSize
+}
+
+// newTestQueue returns a queue without a writer, full of rate budget
+func newTestQueue() *outboundQueue {
+	return &outboundQueue{
+		engine:     newTestEngine(),
+		sessionID:  "session-1",
+		pending:    make(map[string]*outboundItem),
+		tokens:     float64(config.OutboundMaxRate),
+		lastRefill: time.Now(),
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+}
+
+func rowUpdate(id int, seq uint64, newData, oldData map[string]interface{}) PublicationMessage {
+	newData["id"], oldData["id"] = id, id
+	return PublicationMessage{Table: "wh_tasks", Operation: "UPDATE", NewData: newData, OldData: oldData, Sequence: seq}
+}
+
+func TestCoalesceUpdates(t *testing.T) {
+	tests := []struct {
+		name   string
+		queued PublicationMessage
+		newer  PublicationMessage
+		want   PublicationMessage
+	}{
+		{
+			name:   "full on full keeps the queued old row",
+			queued: PublicationMessage{Operation: "UPDATE", NewData: map[string]interface{}{"id": 1, "name": "b"}, OldData: map[string]interface{}{"id": 1, "name": "a"}, Sequence: 1},
+			newer:  PublicationMessage{Operation: "UPDATE", NewData: map[string]interface{}{"id": 1, "name": "c"}, OldData: map[string]interface{}{"id": 1, "name": "b"}, Sequence: 2},
+			want:   PublicationMessage{Operation: "UPDATE", NewData: map[string]interface{}{"id": 1, "name": "c"}, OldData: map[string]interface{}{"id": 1, "name": "a"}, Sequence: 2},
+		},
+		{
+			name:   "delta on delta merges the changed columns",
+			queued: PublicationMessage{Operation: "UPDATE", Encoding: "delta", NewData: map[string]interface{}{"id": 1, "name": "b"}, ChangedColumns: []string{"name"}, Sequence: 1},
+			newer:  PublicationMessage{Operation: "UPDATE", Encoding: "delta", NewData: map[string]interface{}{"id": 1, "status_id": 3}, ChangedColumns: []string{"status_id"}, Sequence: 2},
+			want:   PublicationMessage{Operation: "UPDATE", Encoding: "delta", NewData: map[string]interface{}{"id": 1, "name": "b", "status_id": 3}, ChangedColumns: []string{"name", "status_id"}, Sequence: 2},
+		},
+		{
+			name:   "delta on delta keeps the newest value of a column",
+			queued: PublicationMessage{Operation: "UPDATE", Encoding: "delta", NewData: map[string]interface{}{"id": 1, "name": "b"}, ChangedColumns: []string{"name"}, Sequence: 1},
+			newer:  PublicationMessage{Operation: "UPDATE", Encoding: "delta", NewData: map[string]interface{}{"id": 1, "name": "c"}, ChangedColumns: []string{"name"}, Sequence: 2},
+			want:   PublicationMessage{Operation: "UPDATE", Encoding: "delta", NewData: map[string]interface{}{"id": 1, "name": "c"}, ChangedColumns: []string{"name"}, Sequence: 2},
+		},
+		{
+			name:   "delta on full stays a full row",
+			queued: PublicationMessage{Operation: "UPDATE", NewData: map[string]interface{}{"id": 1, "name": "b", "status_id": 1}, OldData: map[string]interface{}{"id": 1, "name": "a", "status_id": 1}, Sequence: 1},
+			newer:  PublicationMessage{Operation: "UPDATE", Encoding: "delta", NewData: map[string]interface{}{"id": 1, "status_id": 3}, ChangedColumns: []string{"status_id"}, Sequence: 2},
+			want:   PublicationMessage{Operation: "UPDATE", NewData: map[string]interface{}{"id": 1, "name": "b", "status_id": 3}, OldData: map[string]interface{}{"id": 1, "name": "a", "status_id": 1}, Sequence: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := coalesceUpdates(tt.queued, tt.newer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("coalesceUpdates() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// queuedSequences lists the sequence numbers in the order the queue sends them
+func queuedSequences(q *outboundQueue) []uint64 {
+	var sequences []uint64
+	for _, item := range q.items {
+		for _, change := range item.changes {
+			sequences = append(sequences, change.message.Sequence)
+		}
+	}
+	return sequences
+}
+
+func TestEnqueueCoalescingKeepsSequenceOrder(t *testing.T) {
+	withOutboundConfig(t, true, 0, 0)
+	q := newTestQueue()
+
+	q.enqueue([]outboundChange{{message: rowUpdate(1, 1, map[string]interface{}{"name": "b"}, map[string]interface{}{"name": "a"})}})
+	q.enqueue([]outboundChange{{message: rowUpdate(2, 2, map[string]interface{}{"name": "x"}, map[string]interface{}{"name": "w"})}})
+	q.enqueue([]outboundChange{{message: rowUpdate(1, 3, map[string]interface{}{"name": "c"}, map[string]interface{}{"name": "b"})}})
+
+	if got, want := queuedSequences(q), []uint64{2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("queued sequences = %v, want %v", got, want)
+	}
+	merged := q.items[1].changes[0].message
+	if rowValues(merged.NewData)["name"] != "c" || rowValues(merged.OldData)["name"] != "a" {
+		t.Errorf("merged update = %+v, want name c over a", merged)
+	}
+	if q.size != 2 || q.engine.outboundStats.coalesced.Load() != 1 {
+		t.Errorf("size = %d, coalesced = %d; want 2 and 1", q.size, q.engine.outboundStats.coalesced.Load())
+	}
+
+	// A DELETE ends coalescing: a later update of the row is queued on its own
+	q.enqueue([]outboundChange{{message: PublicationMessage{Table: "wh_tasks", Operation: "DELETE", OldData: map[string]interface{}{"id": 1}, Sequence: 4}}})
+	q.enqueue([]outboundChange{{message: rowUpdate(1, 5, map[string]interface{}{"name": "d"}, map[string]interface{}{"name": "c"})}})
+	if got, want := queuedSequences(q), []uint64{2, 3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queued sequences = %v, want %v", got, want)
+	}
+}
+
+func TestTakeRateShaping(t *testing.T) {
+	tests := []struct {
+		name          string
+		queue         bool
+		maxRate       int
+		tokens        float64
+		items         []int // changes per queued item
+		wantItems     int
+		wantRemaining bool
+	}{
+		{"unshaped takes everything", false, 100, 0, []int{1, 1, 3}, 3, false},
+		{"unlimited rate takes everything", true, 0, 0, []int{1, 1, 3}, 3, false},
+		{"budget covers the queue", true, 100, 100, []int{1, 1, 3}, 3, false},
+		{"budget stops at the first item over it", true, 100, 2, []int{1, 1, 3}, 2, true},
+		{"no budget takes nothing", true, 100, 0.5, []int{1, 1}, 0, true},
+		{"oversized transaction goes whole on a full budget", true, 2, 2, []int{5, 1}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withOutboundConfig(t, tt.queue, tt.maxRate, 0)
+			q := newTestQueue()
+			q.tokens = tt.tokens
+			q.lastRefill = time.Now()
+			for _, count := range tt.items {
+				changes := make([]outboundChange, count)
+				q.items = append(q.items, &outboundItem{changes: changes})
+				q.size += count
+			}
+
+			items, remaining := q.take()
+			if len(items) != tt.wantItems || remaining != tt.wantRemaining {
+				t.Errorf("take() = %d items, remaining %v; want %d, %v", len(items), remaining, tt.wantItems, tt.wantRemaining)
+			}
+		})
+	}
+}
+
+func TestRateDelay(t *testing.T) {
+	for _, tt := range []struct {
+		maxRate int
+		want    time.Duration
+	}{{0, 0}, {200, 5 * time.Millisecond}, {1, time.Second}} {
+		withOutboundConfig(t, true, tt.maxRate, 0)
+		if got := rateDelay(); got != tt.want {
+			t.Errorf("rateDelay() with OUTBOUND_MAX_RATE=%d = %v, want %v", tt.maxRate, got, tt.want)
+		}
+	}
+}
+
+func TestEnqueueMessage(t *testing.T) {
+	withOutboundConfig(t, true, 0, 0)
+	q := newTestQueue()
+
+	update := rowUpdate(1, 1, map[string]interface{}{"name": "b"}, map[string]interface{}{"name": "a"})
+	q.enqueue([]outboundChange{{message: update}})
+	if !q.enqueueMessage([]byte(`{"type":"system","sessionId":""}`)) {
+		t.Fatal("enqueueMessage() = false, want true")
+	}
+
+	// A later update of the row is merged and moves behind the message, keeping seq order
+	update = rowUpdate(1, 2, map[string]interface{}{"name": "c"}, map[string]interface{}{"name": "b"})
+	q.enqueue([]outboundChange{{message: update}})
+	if len(q.items) != 2 || q.items[0].message == nil || q.items[1].changes[0].message.Sequence != 2 || q.size != 2 {
+		t.Fatalf("queue = %d items of size %d, want the message then the merged update", len(q.items), q.size)
+	}
+
+	// Nothing is queued after the closing message
+	if !q.enqueueClose([]byte(`{"type":"system","sessionId":""}`), 1000, "Server shutdown") {
+		t.Fatal("enqueueClose() = false, want true")
+	}
+	if q.enqueueMessage([]byte(`{}`)) {
+		t.Error("enqueueMessage() after enqueueClose() = true, want false")
+	}
+	q.enqueue([]outboundChange{{message: rowUpdate(2, 3, map[string]interface{}{}, map[string]interface{}{})}})
+	if len(q.items) != 3 || q.items[2].close == nil {
+		t.Errorf("queue = %d items, want the closing message last", len(q.items))
+	}
+}
+
+func TestEnqueueMessageClosedQueue(t *testing.T) {
+	withOutboundConfig(t, true, 0, 0)
+	q := newTestQueue()
+	q.closed = true
+
+	if q.enqueueMessage([]byte(`{}`)) {
+		t.Error("enqueueMessage() on a closed queue = true, want false")
+	}
+	if q.engine.outboundStats.dropped.Load() != 1 {
+		t.Errorf("dropped = %d, want 1", q.engine.outboundStats.dropped.Load())
+	}
+}
+
+func TestEnqueueReplay(t *testing.T) {
+	change := func(seq uint64) outboundChange {
+		return outboundChange{message: PublicationMessage{Table: "wh_tasks", Operation: "INSERT", NewData: map[string]interface{}{"id": int(seq)}, Sequence: seq}}
+	}
+	tests := []struct {
+		name     string
+		queued   []uint64 // live changes waiting, one item each
+		taken    uint64   // highest seq the writer already took
+		replay   []uint64
+		since    uint64
+		last     uint64
+		want     bool
+		wantSeqs []uint64 // queued after the replay, the resumed message not included
+	}{
+		{name: "empty queue", replay: []uint64{3, 4}, since: 2, last: 4, want: true, wantSeqs: []uint64{3, 4}},
+		{name: "replay goes ahead of newer live changes", queued: []uint64{5, 6}, replay: []uint64{3, 4}, since: 2, last: 4, want: true, wantSeqs: []uint64{3, 4, 5, 6}},
+		{name: "waiting copies of replayed changes are dropped", queued: []uint64{4, 5}, replay: []uint64{3, 4}, since: 2, last: 4, want: true, wantSeqs: []uint64{3, 4, 5}},
+		{name: "filtered out changes still leave the queue", queued: []uint64{4, 5}, replay: []uint64{3}, since: 2, last: 4, want: true, wantSeqs: []uint64{3, 5}},
+		{name: "taken changes up to since", taken: 2, replay: []uint64{3}, since: 2, last: 3, want: true, wantSeqs: []uint64{3}},
+		{name: "newer change already taken", taken: 5, queued: []uint64{6}, replay: []uint64{3, 4}, since: 2, last: 4, want: false, wantSeqs: []uint64{6}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withOutboundConfig(t, true, 0, 0)
+			q := newTestQueue()
+			q.takenSeq = tt.taken
+			for _, seq := range tt.queued {
+				q.enqueue([]outboundChange{change(seq)})
+			}
+			var replay []outboundChange
+			for _, seq := range tt.replay {
+				replay = append(replay, change(seq))
+			}
+
+			if got := q.enqueueReplay(replay, tt.since, tt.last, []byte(`{"type":"system","sessionId":""}`)); got != tt.want {
+				t.Fatalf("enqueueReplay() = %v, want %v", got, tt.want)
+			}
+			if got := queuedSequences(q); !reflect.DeepEqual(got, tt.wantSeqs) {
+				t.Errorf("queued sequences = %v, want %v", got, tt.wantSeqs)
+			}
+			wantSize := len(tt.wantSeqs)
+			if tt.want {
+				wantSize++
+				if resumed := q.items[len(tt.replay)]; resumed.message == nil {
+					t.Error("resumed message does not follow the replayed changes")
+				}
+			}
+			if q.size != wantSize {
+				t.Errorf("size = %d, want %d", q.size, wantSize)
+			}
+
+			// A live broadcast of a replayed change arriving late is not queued again
+			q.enqueue([]outboundChange{change(tt.last)})
+			if got := queuedSequences(q); !reflect.DeepEqual(got, tt.wantSeqs) {
+				t.Errorf("queued sequences after a late copy = %v, want %v", got, tt.wantSeqs)
+			}
+		})
+	}
+}
+
+func TestTakeRecordsTakenSequence(t *testing.T) {
+	withOutboundConfig(t, true, 0, 0)
+	q := newTestQueue()
+	q.enqueue([]outboundChange{{message: PublicationMessage{Table: "wh_tasks", Operation: "INSERT", Sequence: 7}}})
+	q.enqueueMessage([]byte(`{}`))
+
+	q.take()
+	if q.takenSeq != 7 {
+		t.Errorf("takenSeq = %d, want 7", q.takenSeq)
+	}
+}
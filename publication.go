@@ -6,12 +6,9 @@ import (
 	"fmt"
 	"log"
 	"time"
-
-	"github.com/igm/sockjs-go/v3/sockjs"
-	"github.com/lib/pq"
 )
 
-// startPublicationListeners starts listeners for all tenant databases
+// startPublicationListeners starts change sources for all tenant databases
 func (e *RealtimeEngine) startPublicationListeners() {
 	e.mutex.RLock()
 	tenantDBs := make(map[string]*sql.DB)
@@ -30,204 +27,269 @@ func (e *RealtimeEngine) startPublicationListeners() {
 	defer rows.Close()
 
 	for rows.Next() {
-		var tenantName, dbName string
-		if err := rows.Scan(&tenantName, &dbName); err != nil {
+		var tenant TenantDB
+		if err := rows.Scan(&tenant.Name, &tenant.Database); err != nil {
 			log.Printf("⚠️  Error scanning tenant row for listener: %v", err)
 			continue
 		}
 
-		if _, exists := tenantDBs[tenantName]; exists {
-			go e.listenToTenantPublications(tenantName, dbName)
+		if _, exists := tenantDBs[tenant.Name]; exists {
+			go e.startTenantChangeSource(tenant)
 		}
 	}
 }
 
-// listenToTenantPublications listens to PostgreSQL notifications for a specific tenant
-func (e *RealtimeEngine) listenToTenantPublications(tenantName, dbName string) {
-	log.Printf("🎧 Starting publication listener for tenant: %s (database: %s)", tenantName, dbName)
-
-	listener := pq.NewListener(
-		fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-			config.DBHost, config.DBPort, config.DBUsername, config.DBPassword, dbName),
-		10*time.Second,
-		time.Minute,
-		func(ev pq.ListenerEventType, err error) {
-			if err != nil {
-				log.Printf("❌ PostgreSQL listener error for %s: %v", tenantName, err)
-			}
-		})
+// startTenantChangeSource starts the change source configured for a tenant, replacing any running one
+func (e *RealtimeEngine) startTenantChangeSource(tenant TenantDB) {
+	sourceName := changeSourceNameForTenant(tenant.Name)
+	source, err := e.newChangeSource(sourceName)
+	if err != nil {
+		log.Printf("❌ Cannot start change source for tenant %s: %v", tenant.Name, err)
+		return
+	}
 
-	defer listener.Close()
+	e.stopTenantChangeSource(tenant.Name)
 
-	// Listen to the channel that corresponds to the publication
-	channelName := "whagons_tasks_changes"
-	if err := listener.Listen(channelName); err != nil {
-		log.Printf("❌ Failed to listen to channel %s for tenant %s: %v", channelName, tenantName, err)
+	// Group the changes of a transaction when batching is enabled
+	emit := ChangeHandler(e.processChange)
+	var batcher *changeBatcher
+	if config.TxBatching {
+		batcher = newChangeBatcher(e.processChanges)
+		emit = batcher.Add
+	}
+
+	if err := source.Start(tenant, emit); err != nil {
+		log.Printf("❌ Failed to start %s change source for tenant %s: %v", sourceName, tenant.Name, err)
 		return
 	}
 
-	log.Printf("✅ Listening to channel '%s' for tenant: %s", channelName, tenantName)
+	e.mutex.Lock()
+	e.changeSources[tenant.Name] = source
+	if batcher != nil {
+		e.batchers[tenant.Name] = batcher
+	}
+	e.mutex.Unlock()
 
-	for {
-		select {
-		case notification := <-listener.Notify:
-			if notification != nil {
-				e.handlePublicationNotification(tenantName, notification)
-			}
-		case <-time.After(90 * time.Second):
-			// Ping to keep connection alive
-			if err := listener.Ping(); err != nil {
-				log.Printf("❌ Ping failed for tenant %s: %v", tenantName, err)
-				return
-			}
-		}
+	log.Printf("✅ %s change source running for tenant: %s", sourceName, tenant.Name)
+}
+
+// stopTenantChangeSource stops the change source of a tenant, if one is running
+func (e *RealtimeEngine) stopTenantChangeSource(tenantName string) {
+	e.mutex.Lock()
+	source, exists := e.changeSources[tenantName]
+	batcher := e.batchers[tenantName]
+	delete(e.changeSources, tenantName)
+	delete(e.batchers, tenantName)
+	e.mutex.Unlock()
+
+	if exists {
+		source.Stop()
+		log.Printf("🛑 Stopped %s change source for tenant: %s", source.Name(), tenantName)
+	}
+	if batcher != nil {
+		batcher.Stop()
 	}
 }
 
-// handlePublicationNotification processes a PostgreSQL notification
-func (e *RealtimeEngine) handlePublicationNotification(tenantName string, notification *pq.Notification) {
-	log.Printf("📡 Publication notification received from %s: %s", tenantName, notification.Extra)
+// processChange decodes a table change and broadcasts it to the tenant's sessions
+func (e *RealtimeEngine) processChange(change ChangeEvent) {
+	message := e.publishChange(change)
 
-	// Parse the PostgreSQL notification payload once
-	var pgNotification PostgreSQLNotification
-	if err := json.Unmarshal([]byte(notification.Extra), &pgNotification); err != nil {
-		log.Printf("❌ Failed to parse notification JSON from %s: %v", tenantName, err)
-		return
+	// Broadcast to all connected SockJS sessions
+	e.BroadcastPublicationMessage(message)
+}
+
+// processChanges decodes the changes of one transaction and broadcasts them as a single batch
+func (e *RealtimeEngine) processChanges(changes []ChangeEvent) {
+	messages := make([]PublicationMessage, 0, len(changes))
+	for _, change := range changes {
+		messages = append(messages, e.publishChange(change))
 	}
 
+	if len(messages) > 1 {
+		log.Printf("📦 Broadcasting transaction %s of %s as a batch of %d changes",
+			changes[0].TxID, changes[0].TenantName, len(messages))
+	}
+	e.broadcastPublications(messages)
+}
+
+// publishChange decodes a table change into a publication message and numbers it in the tenant stream
+func (e *RealtimeEngine) publishChange(change ChangeEvent) PublicationMessage {
+	tenantName := change.TenantName
+
 	// Create clean publication message
 	message := PublicationMessage{
 		Type:        "database",
 		TenantName:  tenantName,
-		Table:       pgNotification.Table,
-		Operation:   pgNotification.Operation,
-		DBTimestamp: pgNotification.Timestamp,
+		Table:       change.Table,
+		Operation:   change.Operation,
+		DBTimestamp: change.Timestamp,
 		ClientTime:  time.Now().Format(time.RFC3339),
+		TxID:        change.TxID,
 	}
 
-	// Parse task data based on operation
-	switch pgNotification.Operation {
-	case "INSERT":
-		if pgNotification.NewData != nil {
-			var newTask TaskRecord
-			if err := json.Unmarshal(pgNotification.NewData, &newTask); err != nil {
-				log.Printf("❌ Failed to parse new task data: %v", err)
-			} else {
-				message.NewData = &newTask
-			}
-		}
-		message.Message = fmt.Sprintf("New task '%s' created in %s",
-			getTaskName(message.NewData), tenantName)
-
-	case "UPDATE":
-		if pgNotification.NewData != nil {
-			var newTask TaskRecord
-			if err := json.Unmarshal(pgNotification.NewData, &newTask); err != nil {
-				log.Printf("❌ Failed to parse new task data: %v", err)
-			} else {
-				message.NewData = &newTask
-			}
+	// Decode row data with the table's decoder (or as a generic column map)
+	if change.Operation != "DELETE" {
+		newRow, err := e.decodeRow(change.Table, change.NewData)
+		if err != nil {
+			log.Printf("❌ Failed to parse new %s data: %v", change.Table, err)
+		} else {
+			message.NewData = newRow
 		}
-		if pgNotification.OldData != nil {
-			var oldTask TaskRecord
-			if err := json.Unmarshal(pgNotification.OldData, &oldTask); err != nil {
-				log.Printf("❌ Failed to parse old task data: %v", err)
-			} else {
-				message.OldData = &oldTask
-			}
+	}
+	if change.Operation != "INSERT" {
+		oldRow, err := e.decodeRow(change.Table, change.OldData)
+		if err != nil {
+			log.Printf("❌ Failed to parse old %s data: %v", change.Table, err)
+		} else {
+			message.OldData = oldRow
 		}
-		message.Message = fmt.Sprintf("Task '%s' updated in %s",
-			getTaskName(message.NewData), tenantName)
+	}
 
+	switch change.Operation {
+	case "INSERT":
+		message.Message = fmt.Sprintf("New %s row '%s' created in %s",
+			change.Table, rowLabel(message.NewData), tenantName)
+	case "UPDATE":
+		message.Message = fmt.Sprintf("%s row '%s' updated in %s",
+			change.Table, rowLabel(message.NewData), tenantName)
 	case "DELETE":
-		if pgNotification.OldData != nil {
-			var oldTask TaskRecord
-			if err := json.Unmarshal(pgNotification.OldData, &oldTask); err != nil {
-				log.Printf("❌ Failed to parse old task data: %v", err)
-			} else {
-				message.OldData = &oldTask
-			}
-		}
-		message.Message = fmt.Sprintf("Task '%s' deleted from %s",
-			getTaskName(message.OldData), tenantName)
+		message.Message = fmt.Sprintf("%s row '%s' deleted from %s",
+			change.Table, rowLabel(message.OldData), tenantName)
 	}
 
-	log.Printf("🔄 Processed %s operation on %s.%s - broadcasting to sessions",
-		pgNotification.Operation, tenantName, pgNotification.Table)
+	// Number the change and keep it for clients that resume later
+	message = e.getTenantStream(tenantName).Append(message)
 
-	// Broadcast to all connected SockJS sessions
-	e.BroadcastPublicationMessage(message)
+	log.Printf("🔄 Processed %s operation on %s.%s (seq %d) - broadcasting to sessions",
+		change.Operation, tenantName, change.Table, message.Sequence)
+	return message
+}
+
+// BroadcastPublicationMessage sends a publication message to authenticated sessions with tenant access
+func (e *RealtimeEngine) BroadcastPublicationMessage(message PublicationMessage) {
+	e.broadcastPublications([]PublicationMessage{message})
+}
+
+// routedPublication is a publication message with the values computed once for every session
+type routedPublication struct {
+	message    PublicationMessage
+	newValues  map[string]interface{}
+	oldValues  map[string]interface{}
+	delta      *PublicationMessage
+	ability    string                     // required to receive the table's changes
+	authorizer RowAuthorizer              // row-level authorization of the table, nil when none
+	payloads   map[string]json.RawMessage // operation/encoding/message -> message marshaled for those sessions
 }
 
-// getTaskName safely extracts the task name from a TaskRecord
-func getTaskName(task *TaskRecord) string {
-	if task == nil {
-		return "unknown"
+// payloadFor returns the JSON of the variant of a publication a session receives. Sessions get
+// the change as is, delta encoded, or as a LEAVE whose message names why the row left (filter or
+// row policy); each variant is marshaled once per broadcast.
+func (publication *routedPublication) payloadFor(message PublicationMessage) json.RawMessage {
+	variant := message.Operation + "/" + message.Encoding + "/" + message.Message
+	if payload, exists := publication.payloads[variant]; exists {
+		return payload
 	}
-	return task.Name
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("❌ Failed to marshal publication message: %v", err)
+		payload = nil // marshaled again, and logged, when sent
+	}
+	if publication.payloads == nil {
+		publication.payloads = make(map[string]json.RawMessage)
+	}
+	publication.payloads[variant] = payload
+	return payload
 }
 
-// BroadcastPublicationMessage sends a publication message to authenticated sessions with tenant access
-func (e *RealtimeEngine) BroadcastPublicationMessage(message PublicationMessage) {
-	e.mutex.RLock()
-	sessions := make(map[string]sockjs.Session)
-	authSessions := make(map[string]*AuthenticatedSession)
-	for id, session := range e.sessions {
-		sessions[id] = session
+// broadcastPublications sends the changes of one transaction to authenticated sessions with tenant
+// access. Each session receives the changes it is subscribed to, as a single message when only one
+// remains and as a batch message otherwise.
+func (e *RealtimeEngine) broadcastPublications(messages []PublicationMessage) {
+	if len(messages) == 0 {
+		return
 	}
-	for id, authSession := range e.authenticatedSessions {
-		authSessions[id] = authSession
+	tenantName := messages[0].TenantName
+
+	// Only the sessions authenticated for this tenant can access its data
+	sessions := e.tenantSessionList(tenantName)
+	if len(sessions) == 0 {
+		log.Printf("📡 No authorized sessions found for tenant: %s", tenantName)
+		return
 	}
-	e.mutex.RUnlock()
 
-	broadcastCount := 0
-	authorizedCount := 0
+	queuedCount := 0
 
-	for sessionID, session := range sessions {
-		authSession, isAuthenticated := authSessions[sessionID]
+	// Column maps used to evaluate row filters and the delta encoding of UPDATEs,
+	// computed once per change and shared by every session
+	routed := make([]routedPublication, len(messages))
+	for i, message := range messages {
+		routed[i].message = message
+		routed[i].newValues = rowValues(message.NewData)
+		routed[i].oldValues = rowValues(message.OldData)
+		routed[i].delta = deltaMessage(message, routed[i].newValues, routed[i].oldValues)
+		routed[i].ability = tableAbility(message.Table)
+		routed[i].authorizer = e.rowAuthorizer(message.Table)
+	}
 
-		if !isAuthenticated {
-			// Skip unauthenticated sessions (shouldn't happen with new auth flow)
-			log.Printf("⚠️ Skipping unauthenticated session %s", sessionID)
+	// Per-user row policies are resolved for every recipient before the fan-out, which never waits on a query
+	var userIDs []int
+	preloaded := make(map[RowAuthorizer]RowAuthorizer)
+	for i := range routed {
+		preloader, ok := routed[i].authorizer.(rowAuthorizerPreloader)
+		if !ok {
 			continue
 		}
-
-		// Check if the authenticated session can access this tenant's data
-		if !authSession.canAccessTenant(message.TenantName) {
-			log.Printf("🔒 Session %s (tenant: %s) denied access to %s data",
-				sessionID, authSession.TenantName, message.TenantName)
-			continue
+		if _, exists := preloaded[routed[i].authorizer]; !exists {
+			if userIDs == nil {
+				userIDs = sessionUserIDs(sessions)
+			}
+			preloaded[routed[i].authorizer] = preloader.preload(tenantName, userIDs)
 		}
+		routed[i].authorizer = preloaded[routed[i].authorizer]
+	}
 
-		authorizedCount++
-
-		// Set the sessionId for this specific session
-		message.SessionId = sessionID
-
-		jsonMessage, err := json.Marshal(message)
-		if err != nil {
-			log.Printf("❌ Failed to marshal publication message: %v", err)
+	for _, entry := range sessions {
+		// Only deliver readable, subscribed tables and rows matching the session's filters and policies
+		var delivered []outboundChange
+		for i := range routed {
+			publication := &routed[i]
+			if !entry.auth.hasAbility(publication.ability) {
+				continue
+			}
+			sessionMessage, deliver := entry.subscriptions.routeMessage(publication.message, publication.newValues, publication.oldValues)
+			if !deliver {
+				continue
+			}
+			sessionMessage, deliver = authorizeMessage(publication.authorizer, entry.auth, sessionMessage, publication.newValues, publication.oldValues)
+			if !deliver {
+				continue
+			}
+			sessionMessage = entry.subscriptions.encodeMessage(sessionMessage, publication.delta)
+			delivered = append(delivered, outboundChange{message: sessionMessage, payload: publication.payloadFor(sessionMessage)})
+		}
+		if len(delivered) == 0 {
 			continue
 		}
 
-		if err := session.Send(string(jsonMessage)); err != nil {
-			log.Printf("❌ Failed to send to session %s: %v", sessionID, err)
-			// Remove failed session
-			e.mutex.Lock()
-			delete(e.sessions, sessionID)
-			delete(e.authenticatedSessions, sessionID)
-			e.mutex.Unlock()
-		} else {
-			broadcastCount++
-			log.Printf("📤 Sent publication to authenticated session %s (tenant: %s)",
-				sessionID, authSession.TenantName)
-		}
+		// The session's writer sends them, so a slow client cannot hold up the broadcast
+		entry.outbound.enqueue(delivered)
+		queuedCount++
 	}
 
-	if authorizedCount > 0 {
-		log.Printf("📡 Broadcasted publication to %d/%d authorized sessions for tenant: %s",
-			broadcastCount, authorizedCount, message.TenantName)
-	} else {
-		log.Printf("📡 No authorized sessions found for tenant: %s", message.TenantName)
+	log.Printf("📡 Queued publication for %d/%d sessions of tenant: %s", queuedCount, len(sessions), tenantName)
+}
+
+// sessionUserIDs returns the distinct users of the given sessions
+func sessionUserIDs(sessions []sessionEntry) []int {
+	seen := make(map[int]bool)
+	var userIDs []int
+	for _, entry := range sessions {
+		if !seen[entry.auth.UserID] {
+			seen[entry.auth.UserID] = true
+			userIDs = append(userIDs, entry.auth.UserID)
+		}
 	}
+	return userIDs
 }
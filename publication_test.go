@@ -0,0 +1,253 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/igm/sockjs-go/v3/sockjs"
+)
+
+// TestMain runs the tests with the engine defaults; configuration files are never read or written
+func TestMain(m *testing.M) {
+	loadEngineConfig()
+	os.Exit(m.Run())
+}
+
+// newTestEngine returns an engine with its maps initialized and no database connections
+func newTestEngine() *RealtimeEngine {
+	return &RealtimeEngine{
+		tenantDBs:             make(map[string]*sql.DB),
+		sessions:              make(map[string]sockjs.Session),
+		negotiationSessions:   make(map[string]sockjs.Session),
+		authenticatedSessions: make(map[string]*AuthenticatedSession),
+		tokenCache:            make(map[string]*CachedToken),
+		rowDecoders:           make(map[string]RowDecoder),
+		rowAuthorizers:        make(map[string]RowAuthorizer),
+		authenticators:        make(map[string]Authenticator),
+		changeSources:         make(map[string]ChangeSource),
+		subscriptions:         make(map[string]*SessionSubscriptions),
+		streams:               make(map[string]*TenantStream),
+		rowHashTables:         make(map[string]map[string]bool),
+		batchers:              make(map[string]*changeBatcher),
+		outboundQueues:        make(map[string]*outboundQueue),
+		tenantSessions:        make(map[string]*tenantSessions),
+	}
+}
+
+// indexTestSessions adds active sessions to a tenant's index. Their queues have no writer, so
+// what a broadcast enqueues stays there until drainQueue takes it.
+func indexTestSessions(e *RealtimeEngine, tenantName string, count int) []*outboundQueue {
+	index := e.tenantIndex(tenantName, true)
+	queues := make([]*outboundQueue, 0, count)
+	for i := 0; i < count; i++ {
+		sessionID := fmt.Sprintf("session-%05d", i)
+		q := &outboundQueue{
+			engine:    e,
+			sessionID: sessionID,
+			pending:   make(map[string]*outboundItem),
+			wake:      make(chan struct{}, 1),
+			stop:      make(chan struct{}),
+		}
+		entry := &sessionEntry{
+			id:       sessionID,
+			auth:     &AuthenticatedSession{SessionID: sessionID, TenantName: tenantName, UserID: i, Abilities: []string{"*"}},
+			outbound: q,
+		}
+		index.sessions[sessionID] = entry
+		index.users[i] = map[string]*sessionEntry{sessionID: entry}
+		queues = append(queues, q)
+	}
+	return queues
+}
+
+// drainQueue removes everything queued for a session
+func drainQueue(q *outboundQueue) []outboundChange {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var changes []outboundChange
+	for _, item := range q.items {
+		changes = append(changes, item.changes...)
+	}
+	q.items = nil
+	q.pending = make(map[string]*outboundItem)
+	q.size = 0
+	return changes
+}
+
+func benchmarkPublication() PublicationMessage {
+	return PublicationMessage{
+		Type:       "database",
+		TenantName: "acme",
+		Table:      "tasks",
+		Operation:  "UPDATE",
+		NewData: map[string]interface{}{
+			"id": 42, "name": "Replace the boiler", "status_id": 3, "team_id": 7,
+			"description": "The boiler in building B needs replacing before winter",
+		},
+		OldData: map[string]interface{}{
+			"id": 42, "name": "Replace the boiler", "status_id": 2, "team_id": 7,
+			"description": "The boiler in building B needs replacing before winter",
+		},
+		Message:     "tasks row 'Replace the boiler' updated in acme",
+		DBTimestamp: 1700000000,
+		ClientTime:  time.Now().Format(time.RFC3339),
+		Sequence:    1,
+	}
+}
+
+// BenchmarkBroadcastPublications fans one change out to every session of a tenant and builds the
+// frames the writers send: with the payload marshaled once per broadcast and addressed to each
+// session, or with the message marshaled again for every session.
+func BenchmarkBroadcastPublications(b *testing.B) {
+	output := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(output)
+
+	message := benchmarkPublication()
+	for _, sessions := range []int{1000, 10000} {
+		e := newTestEngine()
+		queues := indexTestSessions(e, "acme", sessions)
+
+		b.Run(fmt.Sprintf("sessions=%d/marshal-once", sessions), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				e.broadcastPublications([]PublicationMessage{message})
+				for _, q := range queues {
+					for _, change := range drainQueue(q) {
+						_ = addressedFrame(change.payload, q.sessionID)
+					}
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("sessions=%d/marshal-per-session", sessions), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				e.broadcastPublications([]PublicationMessage{message})
+				for _, q := range queues {
+					for _, change := range drainQueue(q) {
+						change.message.SessionId = q.sessionID
+						if _, err := json.Marshal(change.message); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAddressedFrame(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string
+		sessionID string
+		want      string
+	}{
+		{
+			name:      "empty session id is replaced",
+			payload:   `{"type":"system","sessionId":""}`,
+			sessionID: "abc123",
+			want:      `{"type":"system","sessionId":"abc123"}`,
+		},
+		{
+			name:      "session id is JSON escaped",
+			payload:   `{"type":"system","sessionId":""}`,
+			sessionID: `a"b\c`,
+			want:      `{"type":"system","sessionId":"a\"b\\c"}`,
+		},
+		{
+			name:      "payload already addressed is left alone",
+			payload:   `{"type":"system","sessionId":"other"}`,
+			sessionID: "abc123",
+			want:      `{"type":"system","sessionId":"other"}`,
+		},
+		{
+			name:      "payload without a trailing session id is left alone",
+			payload:   `{"sessionId":"","type":"system"}`,
+			sessionID: "abc123",
+			want:      `{"sessionId":"","type":"system"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addressedFrame([]byte(tt.payload), tt.sessionID); got != tt.want {
+				t.Errorf("addressedFrame() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAddressedFrameMessages guards the field order addressedFrame relies on: every message
+// marshaled once per broadcast must end with its sessionId
+func TestAddressedFrameMessages(t *testing.T) {
+	messages := []struct {
+		name    string
+		message interface{}
+		target  interface{}
+	}{
+		{"PublicationMessage", benchmarkPublication(), &PublicationMessage{}},
+		{"SystemMessage", SystemMessage{Type: "system", Operation: "broadcast", Message: "hello", Data: map[string]interface{}{"a": 1}}, &SystemMessage{}},
+	}
+
+	for _, tt := range messages {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := json.Marshal(tt.message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			frame := addressedFrame(payload, "session-1")
+
+			var decoded struct {
+				SessionId string `json:"sessionId"`
+			}
+			if err := json.Unmarshal([]byte(frame), &decoded); err != nil {
+				t.Fatalf("addressed frame is not valid JSON: %v (%s)", err, frame)
+			}
+			if decoded.SessionId != "session-1" {
+				t.Errorf("sessionId = %q, want %q (is sessionId still the last field?)", decoded.SessionId, "session-1")
+			}
+			if err := json.Unmarshal([]byte(frame), tt.target); err != nil {
+				t.Errorf("addressed frame does not decode as %s: %v", tt.name, err)
+			}
+		})
+	}
+}
+
+func TestPayloadForVariants(t *testing.T) {
+	message := benchmarkPublication()
+	publication := &routedPublication{message: message}
+
+	filterLeave := leaveMessage(message, "left your filter")
+	policyLeave := leaveMessage(message, "is no longer visible to you")
+	delta := message
+	delta.Encoding = "delta"
+
+	variants := []PublicationMessage{message, delta, filterLeave, policyLeave}
+	for _, variant := range variants {
+		var decoded PublicationMessage
+		if err := json.Unmarshal(publication.payloadFor(variant), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Operation != variant.Operation || decoded.Encoding != variant.Encoding || decoded.Message != variant.Message {
+			t.Errorf("payloadFor(%s %q) = %s %q %q", variant.Operation, variant.Message, decoded.Operation, decoded.Encoding, decoded.Message)
+		}
+	}
+	if len(publication.payloads) != len(variants) {
+		t.Errorf("marshaled %d variants, want %d", len(publication.payloads), len(variants))
+	}
+
+	// A variant seen again is reused, not marshaled again
+	publication.payloadFor(filterLeave)
+	if len(publication.payloads) != len(variants) {
+		t.Errorf("marshaled %d variants after reuse, want %d", len(publication.payloads), len(variants))
+	}
+}
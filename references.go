@@ -0,0 +1,183 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// oldRowsRetention drops side table rows nobody fetched (e.g. while whagonsRLE was down)
+const oldRowsRetention = "1 hour"
+
+// drainNotifications collects the notifications already queued behind the first one,
+// so reference payloads can be resolved in batches without waiting for more
+func (s *notifyChangeSource) drainNotifications(first *pq.Notification) []*pq.Notification {
+	batch := []*pq.Notification{first}
+	for len(batch) < config.NotifyBatchSize {
+		select {
+		case notification := <-s.listener.Notify:
+			if notification != nil {
+				batch = append(batch, notification)
+			}
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// resolveReferences fills the rows of reference payloads: current rows are fetched by id with
+// one query per table and old rows are read (and removed) from the side table. Changes whose
+// row no longer exists are dropped, since the following DELETE notification covers them; so are
+// those whose row cannot be fetched, while the other changes of the batch still go out.
+func (s *notifyChangeSource) resolveReferences(tenantName string, notifications []PostgreSQLNotification) []PostgreSQLNotification {
+	idsByTable := make(map[string][]string)
+	var oldRefs []int64
+	for _, notification := range notifications {
+		if !notification.Reference {
+			continue
+		}
+		if notification.Operation != "DELETE" {
+			idsByTable[notification.Table] = append(idsByTable[notification.Table], referenceKey(notification.ID))
+		}
+		if notification.OldRef != nil {
+			oldRefs = append(oldRefs, *notification.OldRef)
+		}
+	}
+	if len(idsByTable) == 0 && len(oldRefs) == 0 {
+		return notifications
+	}
+
+	// Tables whose rows could not be fetched; their changes are dropped, not reported as gone
+	failedTables := make(map[string]bool)
+	rowsByTable := make(map[string]map[string]json.RawMessage, len(idsByTable))
+	var oldRows map[int64]json.RawMessage
+
+	db, err := s.engine.tenantDB(tenantName)
+	if err != nil {
+		log.Printf("❌ Cannot resolve change references for %s: %v", tenantName, err)
+		for table := range idsByTable {
+			failedTables[table] = true
+		}
+	} else {
+		for table, ids := range idsByTable {
+			rows, err := fetchRowsByID(db, table, ids)
+			if err != nil {
+				log.Printf("❌ Failed to fetch %d referenced %s row(s) for %s: %v", len(ids), table, tenantName, err)
+				failedTables[table] = true
+				continue
+			}
+			rowsByTable[table] = rows
+		}
+
+		if oldRows, err = fetchOldRows(db, oldRefs); err != nil {
+			log.Printf("⚠️  Failed to read old rows for %s: %v", tenantName, err)
+		}
+	}
+
+	// Full payloads and DELETEs (known by their key) always go out
+	resolved := make([]PostgreSQLNotification, 0, len(notifications))
+	unresolved := 0
+	for _, notification := range notifications {
+		if !notification.Reference {
+			resolved = append(resolved, notification)
+			continue
+		}
+
+		key := referenceKey(notification.ID)
+		if notification.OldRef != nil {
+			notification.OldData = oldRows[*notification.OldRef]
+		}
+		if notification.Operation == "DELETE" {
+			if notification.OldData == nil {
+				notification.OldData = json.RawMessage(fmt.Sprintf(`{"id":%s}`, notification.ID))
+			}
+		} else {
+			row, exists := rowsByTable[notification.Table][key]
+			if !exists && failedTables[notification.Table] {
+				unresolved++
+				continue
+			}
+			if !exists {
+				log.Printf("🔍 Referenced %s row '%s' of %s is gone, skipping %s", notification.Table, key, tenantName, notification.Operation)
+				continue
+			}
+			notification.NewData = row
+		}
+		resolved = append(resolved, notification)
+	}
+	if unresolved > 0 {
+		log.Printf("⚠️  Dropped %d unresolved change reference(s) of %s", unresolved, tenantName)
+	}
+
+	log.Printf("🔗 Resolved %d change reference(s) for %s", len(notifications), tenantName)
+	return resolved
+}
+
+// referenceKey normalizes a referenced id (JSON number or string) into the key used for lookups
+func referenceKey(id json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(id, &text); err == nil {
+		return text
+	}
+	return string(id)
+}
+
+// fetchRowsByID reads the current rows of a table by id, keyed by id text
+func fetchRowsByID(db *sql.DB, table string, ids []string) (map[string]json.RawMessage, error) {
+	if !identifierPattern.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name: %s", table)
+	}
+
+	query := fmt.Sprintf(`SELECT t.id::text, row_to_json(t)::text FROM %s t WHERE t.id = ANY($1)`, table)
+	rows, err := db.Query(query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]json.RawMessage, len(ids))
+	for rows.Next() {
+		var id, row string
+		if err := rows.Scan(&id, &row); err != nil {
+			return nil, err
+		}
+		result[id] = json.RawMessage(row)
+	}
+	return result, rows.Err()
+}
+
+// fetchOldRows reads old rows from the side table and deletes them along with expired ones
+func fetchOldRows(db *sql.DB, refs []int64) (map[int64]json.RawMessage, error) {
+	result := make(map[int64]json.RawMessage, len(refs))
+	if len(refs) == 0 {
+		return result, nil
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT id, old_data::text FROM %s WHERE id = ANY($1)`, oldRowsTable), pq.Array(refs))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		var row string
+		if err := rows.Scan(&id, &row); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		result[id] = json.RawMessage(row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	deleteQuery := fmt.Sprintf(`DELETE FROM %s WHERE id = ANY($1) OR created_at < now() - interval '%s'`, oldRowsTable, oldRowsRetention)
+	if _, err := db.Exec(deleteQuery, pq.Array(refs)); err != nil {
+		log.Printf("⚠️  Failed to delete fetched old rows: %v", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestResolveReferencesWithoutTenantDatabase(t *testing.T) {
+	source := &notifyChangeSource{engine: newTestEngine()}
+	oldRef := int64(7)
+	notifications := []PostgreSQLNotification{
+		{Table: "wh_tasks", Operation: "INSERT", NewData: json.RawMessage(`{"id":1}`)},
+		{Table: "wh_tasks", Operation: "UPDATE", Reference: true, ID: json.RawMessage(`2`)},
+		{Table: "wh_tasks", Operation: "DELETE", Reference: true, ID: json.RawMessage(`3`), OldRef: &oldRef},
+		{Table: "wh_tasks", Operation: "UPDATE", NewData: json.RawMessage(`{"id":4}`), OldData: json.RawMessage(`{"id":4}`)},
+	}
+
+	resolved := source.resolveReferences("acme", notifications)
+
+	want := []PostgreSQLNotification{
+		notifications[0],
+		{Table: "wh_tasks", Operation: "DELETE", Reference: true, ID: json.RawMessage(`3`), OldRef: &oldRef, OldData: json.RawMessage(`{"id":3}`)},
+		notifications[3],
+	}
+	if !reflect.DeepEqual(resolved, want) {
+		t.Errorf("resolveReferences() = %+v, want %+v", resolved, want)
+	}
+}
+
+func TestResolveReferencesWithoutReferences(t *testing.T) {
+	source := &notifyChangeSource{engine: newTestEngine()}
+	notifications := []PostgreSQLNotification{
+		{Table: "wh_tasks", Operation: "INSERT", NewData: json.RawMessage(`{"id":1}`)},
+	}
+	if resolved := source.resolveReferences("acme", notifications); !reflect.DeepEqual(resolved, notifications) {
+		t.Errorf("resolveReferences() = %+v, want the notifications unchanged", resolved)
+	}
+}
+
+func TestReferenceKey(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{`42`, "42"},
+		{`"42"`, "42"},
+		{`"9b1d-uuid"`, "9b1d-uuid"},
+	}
+	for _, tt := range tests {
+		if got := referenceKey(json.RawMessage(tt.id)); got != tt.want {
+			t.Errorf("referenceKey(%s) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
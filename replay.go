@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/igm/sockjs-go/v3/sockjs"
+)
+
+// replayLogEntry is one line of a tenant's on-disk replay log
+type replayLogEntry struct {
+	Epoch   string             `json:"epoch"`
+	Message PublicationMessage `json:"message"`
+}
+
+// getTenantStream returns the stream of a tenant, creating (and restoring from disk) on first use
+func (e *RealtimeEngine) getTenantStream(tenantName string) *TenantStream {
+	e.mutex.RLock()
+	stream, exists := e.streams[tenantName]
+	e.mutex.RUnlock()
+	if exists {
+		return stream
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if stream, exists := e.streams[tenantName]; exists {
+		return stream
+	}
+
+	stream = newTenantStream(tenantName, config.ReplayBufferSize)
+	if config.ReplayDir != "" {
+		if err := stream.openLog(config.ReplayDir); err != nil {
+			log.Printf("⚠️  Replay log disabled for tenant %s: %v", tenantName, err)
+		}
+	}
+	e.streams[tenantName] = stream
+	return stream
+}
+
+// newTenantStream creates an empty stream with a fresh epoch
+func newTenantStream(tenantName string, capacity int) *TenantStream {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &TenantStream{
+		TenantName: tenantName,
+		Epoch:      strconv.FormatInt(time.Now().UnixNano(), 36),
+		buffer:     make([]PublicationMessage, capacity),
+	}
+}
+
+// Append assigns the next sequence number to a message and stores it in the replay buffer
+func (s *TenantStream) Append(message PublicationMessage) PublicationMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sequence++
+	message.Sequence = s.sequence
+	message.SessionId = ""
+	s.store(message)
+
+	if s.logFile != nil {
+		s.writeLog(message)
+	}
+	return message
+}
+
+// store puts a message into the ring buffer, evicting the oldest one when full
+func (s *TenantStream) store(message PublicationMessage) {
+	s.buffer[s.next] = message
+	s.next = (s.next + 1) % len(s.buffer)
+	if s.count < len(s.buffer) {
+		s.count++
+	}
+}
+
+// Since returns the changes after a sequence number. ok is false when the client must resync:
+// the epoch changed, the sequence is from the future, or the buffer no longer reaches back far enough.
+func (s *TenantStream) Since(since uint64, epoch string) (messages []PublicationMessage, ok bool, reason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if epoch != "" && epoch != s.Epoch {
+		return nil, false, "stream epoch changed"
+	}
+	if since > s.sequence {
+		return nil, false, "sequence is ahead of the server"
+	}
+
+	oldest := s.sequence - uint64(s.count) + 1
+	if since+1 < oldest {
+		return nil, false, "changes are no longer buffered"
+	}
+
+	missed := int(s.sequence - since)
+	messages = make([]PublicationMessage, 0, missed)
+	for i := s.count - missed; i < s.count; i++ {
+		index := (s.next - s.count + i + len(s.buffer)) % len(s.buffer)
+		messages = append(messages, s.buffer[index])
+	}
+	return messages, true, ""
+}
+
+// Position returns the stream epoch and the last assigned sequence number
+func (s *TenantStream) Position() (string, uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.Epoch, s.sequence
+}
+
+// replayLogPath returns the path of a tenant's replay log
+func replayLogPath(dir, tenantName string) string {
+	return filepath.Join(dir, slotNameSanitizer.ReplaceAllString(tenantName, "_")+".jsonl")
+}
+
+// openLog restores the buffer from the tenant's replay log and keeps the log open for appending
+func (s *TenantStream) openLog(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create replay dir: %w", err)
+	}
+
+	path := replayLogPath(dir, s.TenantName)
+	if data, err := os.ReadFile(path); err == nil {
+		s.restore(data)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read replay log: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open replay log: %w", err)
+	}
+	s.logFile = file
+
+	log.Printf("💾 Replay log for tenant %s: %s (epoch %s, seq %d, %d buffered)",
+		s.TenantName, path, s.Epoch, s.sequence, s.count)
+	return nil
+}
+
+// restore loads the entries of the latest epoch from a replay log
+func (s *TenantStream) restore(data []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.UseNumber()
+
+		var entry replayLogEntry
+		if err := decoder.Decode(&entry); err != nil || entry.Epoch == "" {
+			continue
+		}
+		if entry.Epoch != s.Epoch {
+			// A new epoch in the log restarts the sequence
+			s.Epoch = entry.Epoch
+			s.sequence = 0
+			s.count = 0
+			s.next = 0
+		}
+		if entry.Message.Sequence <= s.sequence {
+			continue
+		}
+		s.sequence = entry.Message.Sequence
+		s.store(entry.Message)
+		s.logLines++
+	}
+}
+
+// writeLog appends a message to the replay log, compacting the log when it grows past twice the buffer
+func (s *TenantStream) writeLog(message PublicationMessage) {
+	line, err := json.Marshal(replayLogEntry{Epoch: s.Epoch, Message: message})
+	if err != nil {
+		log.Printf("⚠️  Failed to encode replay log entry for tenant %s: %v", s.TenantName, err)
+		return
+	}
+	if _, err := s.logFile.Write(append(line, '\n')); err != nil {
+		log.Printf("⚠️  Failed to write replay log for tenant %s: %v", s.TenantName, err)
+		return
+	}
+
+	s.logLines++
+	if s.logLines > 2*len(s.buffer) {
+		s.compactLog()
+	}
+}
+
+// compactLog rewrites the replay log with the buffered changes only
+func (s *TenantStream) compactLog() {
+	path := s.logFile.Name()
+	tmpPath := path + ".tmp"
+
+	var content bytes.Buffer
+	for i := 0; i < s.count; i++ {
+		index := (s.next - s.count + i + len(s.buffer)) % len(s.buffer)
+		line, err := json.Marshal(replayLogEntry{Epoch: s.Epoch, Message: s.buffer[index]})
+		if err != nil {
+			continue
+		}
+		content.Write(line)
+		content.WriteByte('\n')
+	}
+
+	if err := os.WriteFile(tmpPath, content.Bytes(), 0600); err != nil {
+		log.Printf("⚠️  Failed to compact replay log for tenant %s: %v", s.TenantName, err)
+		return
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		log.Printf("⚠️  Failed to replace replay log for tenant %s: %v", s.TenantName, err)
+		return
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("⚠️  Failed to reopen replay log for tenant %s: %v", s.TenantName, err)
+		s.logFile.Close()
+		s.logFile = nil
+		return
+	}
+	s.logFile.Close()
+	s.logFile = file
+	s.logLines = s.count
+}
+
+// handleResume replays the changes a client missed since its last sequence number,
+// or tells it to resync when they are no longer available. The replay goes through the session's
+// send queue, ahead of the live changes waiting there, so the client receives every seq in order.
+func (e *RealtimeEngine) handleResume(session sockjs.Session, authSession *AuthenticatedSession, command ClientCommand) error {
+	if command.Since == nil {
+		return e.sendCommandError(session, command, "The 'since' sequence number is required")
+	}
+
+	e.mutex.RLock()
+	subscriptions := e.subscriptions[session.ID()]
+	outbound := e.outboundQueues[session.ID()]
+	e.mutex.RUnlock()
+	if outbound == nil {
+		return e.sendCommandError(session, command, "Session is not active")
+	}
+
+	stream := e.getTenantStream(authSession.TenantName)
+	messages, ok, reason := stream.Since(*command.Since, command.Epoch)
+	epoch, lastSequence := stream.Position()
+	if ok && len(messages) > 0 {
+		// Changes appended after Since are broadcast to the session like any live change
+		lastSequence = messages[len(messages)-1].Sequence
+	} else if ok {
+		lastSequence = *command.Since
+	}
+
+	data := map[string]interface{}{
+		"epoch":    epoch,
+		"last_seq": lastSequence,
+		"since":    *command.Since,
+	}
+	if command.RequestID != "" {
+		data["request_id"] = command.RequestID
+	}
+
+	var replay []outboundChange
+	if ok {
+		for _, message := range messages {
+			if !authSession.canReadTable(message.Table) {
+				continue
+			}
+			newValues, oldValues := rowValues(message.NewData), rowValues(message.OldData)
+			sessionMessage, deliver := subscriptions.routeMessage(message, newValues, oldValues)
+			if !deliver {
+				continue
+			}
+			sessionMessage, deliver = authorizeMessage(e.rowAuthorizer(message.Table), authSession, sessionMessage, newValues, oldValues)
+			if !deliver {
+				continue
+			}
+			sessionMessage = subscriptions.encodeMessage(sessionMessage, deltaMessage(message, newValues, oldValues))
+			replay = append(replay, outboundChange{message: sessionMessage})
+		}
+
+		data["replayed"] = len(replay)
+		resumed, err := json.Marshal(SystemMessage{
+			Type:      "system",
+			Operation: "resumed",
+			Message:   fmt.Sprintf("Resumed after sequence %d (%d changes replayed)", *command.Since, len(replay)),
+			Data:      data,
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+		if err != nil {
+			log.Printf("❌ Failed to marshal resumed message: %v", err)
+			return nil
+		}
+		if outbound.enqueueReplay(replay, *command.Since, lastSequence, resumed) {
+			log.Printf("⏪ Replaying %d/%d missed changes to session %s (tenant: %s, since: %d)",
+				len(replay), len(messages), session.ID(), authSession.TenantName, *command.Since)
+			return nil
+		}
+		// Newer live changes already went out, the replay would reach the client after them
+		reason = "newer changes were already delivered to this session"
+		delete(data, "replayed")
+	}
+
+	log.Printf("🔄 Session %s must resync (tenant: %s, since: %d): %s",
+		session.ID(), authSession.TenantName, *command.Since, reason)
+	data["reason"] = reason
+	outbound.enqueueJSON(SystemMessage{
+		Type:      "system",
+		Operation: "resync_required",
+		Message:   fmt.Sprintf("Resync required: %s", reason),
+		Data:      data,
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+	return nil
+}
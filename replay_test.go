@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io"
+	"log"
+	"slices"
+	"testing"
+)
+
+// appendTestChanges appends count changes to a stream
+func appendTestChanges(s *TenantStream, count int) {
+	for i := 0; i < count; i++ {
+		s.Append(PublicationMessage{Type: "database", Table: "tasks", Operation: "UPDATE", SessionId: "sender"})
+	}
+}
+
+func sequences(messages []PublicationMessage) []uint64 {
+	result := make([]uint64, 0, len(messages))
+	for _, message := range messages {
+		result = append(result, message.Sequence)
+	}
+	return result
+}
+
+func TestTenantStreamSince(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		appended int
+		since    uint64
+		epoch    string // "current" is replaced by the stream's epoch
+		want     []uint64
+		ok       bool
+		reason   string
+	}{
+		{name: "nothing missed", capacity: 5, appended: 3, since: 3, epoch: "current", want: []uint64{}, ok: true},
+		{name: "missed changes", capacity: 5, appended: 3, since: 1, epoch: "current", want: []uint64{2, 3}, ok: true},
+		{name: "from the start", capacity: 5, appended: 3, since: 0, want: []uint64{1, 2, 3}, ok: true},
+		{name: "empty stream", capacity: 5, appended: 0, since: 0, want: []uint64{}, ok: true},
+		{name: "without an epoch", capacity: 5, appended: 3, since: 2, want: []uint64{3}, ok: true},
+		{name: "wrapped buffer", capacity: 5, appended: 12, since: 8, epoch: "current", want: []uint64{9, 10, 11, 12}, ok: true},
+		{name: "wrapped buffer, oldest buffered", capacity: 5, appended: 12, since: 7, want: []uint64{8, 9, 10, 11, 12}, ok: true},
+		{name: "evicted changes", capacity: 5, appended: 12, since: 6, reason: "changes are no longer buffered"},
+		{name: "from the start after eviction", capacity: 5, appended: 12, since: 0, reason: "changes are no longer buffered"},
+		{name: "sequence ahead of the server", capacity: 5, appended: 3, since: 4, reason: "sequence is ahead of the server"},
+		{name: "other epoch", capacity: 5, appended: 3, since: 3, epoch: "previous", reason: "stream epoch changed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := newTenantStream("acme", tt.capacity)
+			appendTestChanges(stream, tt.appended)
+
+			epoch := tt.epoch
+			if epoch == "current" {
+				epoch = stream.Epoch
+			}
+			messages, ok, reason := stream.Since(tt.since, epoch)
+			if ok != tt.ok || reason != tt.reason {
+				t.Fatalf("Since(%d) = ok %v %q, want ok %v %q", tt.since, ok, reason, tt.ok, tt.reason)
+			}
+			if tt.ok && !slices.Equal(sequences(messages), tt.want) {
+				t.Errorf("Since(%d) = %v, want %v", tt.since, sequences(messages), tt.want)
+			}
+			for _, message := range messages {
+				if message.SessionId != "" {
+					t.Errorf("buffered change %d keeps the sender's session id", message.Sequence)
+				}
+			}
+		})
+	}
+}
+
+func TestTenantStreamPosition(t *testing.T) {
+	stream := newTenantStream("acme", 0)
+	appendTestChanges(stream, 4)
+
+	epoch, sequence := stream.Position()
+	if epoch != stream.Epoch || sequence != 4 {
+		t.Errorf("Position() = %s %d, want %s 4", epoch, sequence, stream.Epoch)
+	}
+	// A capacity below one still buffers the latest change
+	if messages, ok, _ := stream.Since(3, epoch); !ok || !slices.Equal(sequences(messages), []uint64{4}) {
+		t.Errorf("Since(3) = %v %v, want [4] true", sequences(messages), ok)
+	}
+}
+
+func TestTenantStreamReplayLog(t *testing.T) {
+	output := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(output)
+
+	dir := t.TempDir()
+	stream := newTenantStream("acme", 3)
+	if err := stream.openLog(dir); err != nil {
+		t.Fatal(err)
+	}
+	// Enough to compact the log at least once
+	appendTestChanges(stream, 10)
+	stream.logFile.Close()
+
+	restored := newTenantStream("acme", 3)
+	if err := restored.openLog(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer restored.logFile.Close()
+
+	epoch, sequence := restored.Position()
+	if epoch != stream.Epoch || sequence != 10 {
+		t.Fatalf("restored position = %s %d, want %s 10", epoch, sequence, stream.Epoch)
+	}
+	messages, ok, reason := restored.Since(7, epoch)
+	if !ok || !slices.Equal(sequences(messages), []uint64{8, 9, 10}) {
+		t.Errorf("restored Since(7) = %v %v %q, want [8 9 10]", sequences(messages), ok, reason)
+	}
+	if _, ok, _ := restored.Since(6, epoch); ok {
+		t.Error("restored stream replays changes beyond its buffer")
+	}
+}
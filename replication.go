@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// postgresEpoch is the reference point of pgoutput commit timestamps
+var postgresEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// slotNameSanitizer replaces characters not allowed in replication slot names
+var slotNameSanitizer = regexp.MustCompile(`[^a-z0-9_]`)
+
+// replicationConsumer streams committed changes for one tenant from a logical replication slot
+type replicationConsumer struct {
+	tenantName   string
+	slotName     string
+	plugin       string
+	publication  string
+	db           *sql.DB
+	relations    map[uint32]*pgoutputRelation // pgoutput relation id -> relation metadata
+	confirmedLSN string
+}
+
+// pgoutputRelation describes a table as announced by a pgoutput Relation message
+type pgoutputRelation struct {
+	Namespace string
+	Name      string
+	Columns   []pgoutputColumn
+}
+
+// pgoutputColumn describes a single column of a pgoutput relation
+type pgoutputColumn struct {
+	Name    string
+	TypeOID uint32
+}
+
+// replicationTransaction holds the metadata of the transaction currently being decoded
+type replicationTransaction struct {
+	XID       uint32
+	ID        string // commit LSN (pgoutput) or xid (wal2json), used to group the transaction's changes
+	Timestamp float64
+	Committed bool // set when the commit record was decoded
+}
+
+// replicationSlotName builds a valid, per-tenant replication slot name
+func replicationSlotName(dbName string) string {
+	name := strings.ToLower(config.ReplicationSlotPrefix + "_" + dbName)
+	name = slotNameSanitizer.ReplaceAllString(name, "_")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return name
+}
+
+// newReplicationConsumer creates a consumer for the tenant's replication slot
+func newReplicationConsumer(tenant TenantDB, db *sql.DB) *replicationConsumer {
+	return &replicationConsumer{
+		tenantName:  tenant.Name,
+		slotName:    replicationSlotName(tenant.Database),
+		plugin:      config.ReplicationPlugin,
+		publication: config.ReplicationPublication,
+		db:          db,
+		relations:   make(map[uint32]*pgoutputRelation),
+	}
+}
+
+// run polls the slot until stop is closed. The slot keeps changes made while
+// whagonsRLE is down, so they are delivered on the next start.
+func (c *replicationConsumer) run(stop <-chan struct{}, emit ChangeHandler) {
+	pollInterval := time.Duration(config.ReplicationPollIntervalMs) * time.Millisecond
+	retryDelay := pollInterval
+
+	for {
+		delay := pollInterval
+
+		changeCount, err := c.poll(emit)
+		if err != nil {
+			log.Printf("❌ Replication poll failed for tenant %s: %v", c.tenantName, err)
+			// Back off on repeated errors, up to one minute
+			retryDelay *= 2
+			if retryDelay > time.Minute {
+				retryDelay = time.Minute
+			}
+			delay = retryDelay
+		} else {
+			retryDelay = pollInterval
+			// Keep draining without delay while the slot has a backlog
+			if changeCount >= config.ReplicationMaxChangesPerPoll {
+				delay = 0
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// ensureSlot creates the logical replication slot if it does not exist yet
+func (c *replicationConsumer) ensureSlot() error {
+	var existingPlugin string
+	err := c.db.QueryRow("SELECT plugin FROM pg_replication_slots WHERE slot_name = $1", c.slotName).Scan(&existingPlugin)
+	if err == nil {
+		if existingPlugin != c.plugin {
+			return fmt.Errorf("slot %s uses plugin %s, expected %s", c.slotName, existingPlugin, c.plugin)
+		}
+		log.Printf("✅ Resuming replication slot %s for tenant %s", c.slotName, c.tenantName)
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("failed to look up replication slot: %w", err)
+	}
+
+	if _, err := c.db.Exec("SELECT pg_create_logical_replication_slot($1, $2)", c.slotName, c.plugin); err != nil {
+		return fmt.Errorf("failed to create replication slot: %w", err)
+	}
+
+	log.Printf("✅ Created replication slot %s for tenant %s", c.slotName, c.tenantName)
+	return nil
+}
+
+// poll decodes pending changes from the slot, emits them and confirms the last processed LSN
+func (c *replicationConsumer) poll(emit ChangeHandler) (int, error) {
+	var rows *sql.Rows
+	var err error
+
+	switch c.plugin {
+	case "pgoutput":
+		rows, err = c.db.Query(
+			`SELECT lsn::text, data FROM pg_logical_slot_peek_binary_changes($1, NULL, $2,
+				'proto_version', '1', 'publication_names', $3)`,
+			c.slotName, config.ReplicationMaxChangesPerPoll, c.publication)
+	case "wal2json":
+		rows, err = c.db.Query(
+			`SELECT lsn::text, data FROM pg_logical_slot_peek_changes($1, NULL, $2,
+				'format-version', '2', 'include-xids', '1', 'include-timestamp', '1')`,
+			c.slotName, config.ReplicationMaxChangesPerPoll)
+	default:
+		return 0, fmt.Errorf("unsupported replication plugin: %s", c.plugin)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to peek slot changes: %w", err)
+	}
+	defer rows.Close()
+
+	var transaction replicationTransaction
+	var pending []ChangeEvent
+	var lastLSN string
+	changeCount := 0
+
+	for rows.Next() {
+		var lsn string
+		var data []byte
+		if err := rows.Scan(&lsn, &data); err != nil {
+			return changeCount, fmt.Errorf("failed to scan slot change: %w", err)
+		}
+		lastLSN = lsn
+		changeCount++
+
+		var change *ChangeEvent
+		var decodeErr error
+		if c.plugin == "pgoutput" {
+			change, decodeErr = c.decodePgoutput(data, &transaction)
+		} else {
+			change, decodeErr = decodeWal2JSON(data, &transaction)
+		}
+		if decodeErr != nil {
+			log.Printf("⚠️  Skipping undecodable change at %s for tenant %s: %v", lsn, c.tenantName, decodeErr)
+			continue
+		}
+		if change != nil {
+			change.TenantName = c.tenantName
+			change.Source = "replication"
+			change.TxID = transaction.ID
+			pending = append(pending, *change)
+		}
+
+		// Emit a transaction's changes once its commit is decoded, marking the last one
+		if transaction.Committed {
+			if len(pending) > 0 {
+				pending[len(pending)-1].TxEnd = true
+			}
+			for _, pendingChange := range pending {
+				emit(pendingChange)
+			}
+			pending = nil
+			transaction.Committed = false
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return changeCount, fmt.Errorf("failed to read slot changes: %w", err)
+	}
+
+	// upto_nchanges is only checked at transaction boundaries, so a poll returns whole transactions
+	// and always ends on a commit, however large the last one. Changes still pending here belong to
+	// a transaction whose commit could not be decoded; they are emitted rather than lost.
+	for _, pendingChange := range pending {
+		emit(pendingChange)
+	}
+
+	if lastLSN != "" {
+		if _, err := c.db.Exec("SELECT pg_replication_slot_advance($1, $2::pg_lsn)", c.slotName, lastLSN); err != nil {
+			return changeCount, fmt.Errorf("failed to confirm LSN %s: %w", lastLSN, err)
+		}
+		c.confirmedLSN = lastLSN
+		log.Printf("✅ Confirmed LSN %s for tenant %s (%d changes)", lastLSN, c.tenantName, changeCount)
+	}
+
+	return changeCount, nil
+}
+
+// decodePgoutput decodes one pgoutput (protocol v1) message. Only row changes produce a notification.
+func (c *replicationConsumer) decodePgoutput(data []byte, transaction *replicationTransaction) (*ChangeEvent, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	reader := &pgoutputReader{data: data[1:]}
+
+	switch data[0] {
+	case 'B': // Begin: final LSN, commit timestamp, xid
+		finalLSN := reader.uint64()
+		commitTime := int64(reader.uint64())
+		transaction.XID = reader.uint32()
+		transaction.ID = fmt.Sprintf("%X/%X", finalLSN>>32, uint32(finalLSN))
+		transaction.Timestamp = float64(postgresEpoch.UnixMicro()+commitTime) / 1e6
+		return nil, reader.err
+
+	case 'C': // Commit
+		transaction.Committed = true
+		return nil, nil
+
+	case 'R': // Relation: id, namespace, name, replica identity, columns
+		relationID := reader.uint32()
+		relation := &pgoutputRelation{
+			Namespace: reader.string(),
+			Name:      reader.string(),
+		}
+		reader.byte()
+		columnCount := int(reader.uint16())
+		for i := 0; i < columnCount && reader.err == nil; i++ {
+			reader.byte()
+			column := pgoutputColumn{Name: reader.string(), TypeOID: reader.uint32()}
+			reader.uint32()
+			relation.Columns = append(relation.Columns, column)
+		}
+		if reader.err == nil {
+			c.relations[relationID] = relation
+		}
+		return nil, reader.err
+
+	case 'I', 'U', 'D':
+		relation, exists := c.relations[reader.uint32()]
+		if !exists {
+			return nil, fmt.Errorf("change for unknown relation")
+		}
+
+		change := &ChangeEvent{
+			Table:     relation.Name,
+			Timestamp: transaction.Timestamp,
+		}
+
+		switch data[0] {
+		case 'I':
+			change.Operation = "INSERT"
+			reader.byte() // 'N'
+			change.NewData = reader.tuple(relation)
+		case 'U':
+			change.Operation = "UPDATE"
+			marker := reader.byte()
+			if marker == 'K' || marker == 'O' {
+				change.OldData = reader.tuple(relation)
+				reader.byte() // 'N'
+			}
+			change.NewData = reader.tuple(relation)
+		case 'D':
+			change.Operation = "DELETE"
+			reader.byte() // 'K' or 'O'
+			change.OldData = reader.tuple(relation)
+		}
+		return change, reader.err
+	}
+
+	// Commit, Origin, Type and Truncate messages carry no row changes
+	return nil, nil
+}
+
+// pgoutputReader reads big-endian pgoutput fields, remembering the first error
+type pgoutputReader struct {
+	data []byte
+	err  error
+}
+
+func (r *pgoutputReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = fmt.Errorf("truncated pgoutput message")
+		return nil
+	}
+	chunk := r.data[:n]
+	r.data = r.data[n:]
+	return chunk
+}
+
+func (r *pgoutputReader) byte() byte {
+	if chunk := r.take(1); chunk != nil {
+		return chunk[0]
+	}
+	return 0
+}
+
+func (r *pgoutputReader) uint16() uint16 {
+	if chunk := r.take(2); chunk != nil {
+		return binary.BigEndian.Uint16(chunk)
+	}
+	return 0
+}
+
+func (r *pgoutputReader) uint32() uint32 {
+	if chunk := r.take(4); chunk != nil {
+		return binary.BigEndian.Uint32(chunk)
+	}
+	return 0
+}
+
+func (r *pgoutputReader) uint64() uint64 {
+	if chunk := r.take(8); chunk != nil {
+		return binary.BigEndian.Uint64(chunk)
+	}
+	return 0
+}
+
+func (r *pgoutputReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	end := bytes.IndexByte(r.data, 0)
+	if end < 0 {
+		r.err = fmt.Errorf("unterminated pgoutput string")
+		return ""
+	}
+	value := string(r.data[:end])
+	r.data = r.data[end+1:]
+	return value
+}
+
+// tuple reads a TupleData block and converts it to a JSON row
+func (r *pgoutputReader) tuple(relation *pgoutputRelation) json.RawMessage {
+	columnCount := int(r.uint16())
+	row := make(map[string]interface{}, columnCount)
+
+	for i := 0; i < columnCount && r.err == nil; i++ {
+		kind := r.byte()
+		if i >= len(relation.Columns) {
+			r.err = fmt.Errorf("tuple has more columns than relation %s", relation.Name)
+			break
+		}
+		column := relation.Columns[i]
+
+		switch kind {
+		case 'n':
+			row[column.Name] = nil
+		case 'u':
+			// Unchanged TOAST value - not sent by the server, leave it out
+		case 't':
+			length := int(r.uint32())
+			row[column.Name] = pgTextToJSON(column.TypeOID, string(r.take(length)))
+		}
+	}
+
+	if r.err != nil {
+		return nil
+	}
+	raw, err := json.Marshal(row)
+	if err != nil {
+		r.err = err
+		return nil
+	}
+	return raw
+}
+
+// pgTextToJSON converts a column in PostgreSQL text format to a JSON-friendly value
+func pgTextToJSON(typeOID uint32, value string) interface{} {
+	switch typeOID {
+	case 16: // bool
+		return value == "t"
+	case 20, 21, 23, 700, 701, 1700: // int8, int2, int4, float4, float8, numeric
+		if value == "NaN" || strings.Contains(value, "Infinity") {
+			return value
+		}
+		return json.Number(value)
+	case 114, 3802: // json, jsonb
+		return json.RawMessage(value)
+	}
+	return value
+}
+
+// wal2jsonChange is a single change in wal2json format-version 2
+type wal2jsonChange struct {
+	Action    string           `json:"action"`
+	XID       uint32           `json:"xid"`
+	Timestamp string           `json:"timestamp"`
+	Table     string           `json:"table"`
+	Columns   []wal2jsonColumn `json:"columns"`
+	Identity  []wal2jsonColumn `json:"identity"`
+}
+
+// wal2jsonColumn is a column value in wal2json format-version 2
+type wal2jsonColumn struct {
+	Name  string          `json:"name"`
+	Value json.RawMessage `json:"value"`
+}
+
+// decodeWal2JSON decodes one wal2json (format-version 2) change. Begin/commit records produce no notification.
+func decodeWal2JSON(data []byte, transaction *replicationTransaction) (*ChangeEvent, error) {
+	var record wal2jsonChange
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+
+	if record.Action == "C" {
+		transaction.Committed = true
+		return nil, nil
+	}
+	if record.Action == "B" {
+		transaction.XID = record.XID
+		transaction.ID = strconv.FormatUint(uint64(record.XID), 10)
+		transaction.Timestamp = float64(time.Now().UnixMicro()) / 1e6
+		if commitTime, err := time.Parse("2006-01-02 15:04:05.999999-07", record.Timestamp); err == nil {
+			transaction.Timestamp = float64(commitTime.UnixMicro()) / 1e6
+		}
+		return nil, nil
+	}
+
+	change := &ChangeEvent{
+		Table:     record.Table,
+		Timestamp: transaction.Timestamp,
+	}
+
+	switch record.Action {
+	case "I":
+		change.Operation = "INSERT"
+		change.NewData = wal2jsonRow(record.Columns)
+	case "U":
+		change.Operation = "UPDATE"
+		change.NewData = wal2jsonRow(record.Columns)
+		change.OldData = wal2jsonRow(record.Identity)
+	case "D":
+		change.Operation = "DELETE"
+		change.OldData = wal2jsonRow(record.Identity)
+	default:
+		return nil, nil
+	}
+	return change, nil
+}
+
+// wal2jsonRow converts wal2json column values to a JSON row
+func wal2jsonRow(columns []wal2jsonColumn) json.RawMessage {
+	if len(columns) == 0 {
+		return nil
+	}
+	row := make(map[string]json.RawMessage, len(columns))
+	for _, column := range columns {
+		row[column.Name] = column.Value
+	}
+	raw, _ := json.Marshal(row)
+	return raw
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// pgoutputMessage builds a pgoutput message from its fields: byte, uint16, uint32 and uint64
+// values are written big-endian, strings null-terminated and []byte as is
+func pgoutputMessage(kind byte, fields ...interface{}) []byte {
+	message := []byte{kind}
+	for _, field := range fields {
+		switch value := field.(type) {
+		case byte:
+			message = append(message, value)
+		case uint16:
+			message = binary.BigEndian.AppendUint16(message, value)
+		case uint32:
+			message = binary.BigEndian.AppendUint32(message, value)
+		case uint64:
+			message = binary.BigEndian.AppendUint64(message, value)
+		case string:
+			message = append(append(message, value...), 0)
+		case []byte:
+			message = append(message, value...)
+		}
+	}
+	return message
+}
+
+// pgoutputTuple builds TupleData: a string is a text value, nil a null and 'u' an unchanged TOAST value
+func pgoutputTuple(values ...interface{}) []byte {
+	tuple := binary.BigEndian.AppendUint16(nil, uint16(len(values)))
+	for _, value := range values {
+		switch value := value.(type) {
+		case nil:
+			tuple = append(tuple, 'n')
+		case rune:
+			tuple = append(tuple, 'u')
+		case string:
+			tuple = append(tuple, 't')
+			tuple = binary.BigEndian.AppendUint32(tuple, uint32(len(value)))
+			tuple = append(tuple, value...)
+		}
+	}
+	return tuple
+}
+
+// testRelation announces wh_tasks (id int8, name text, done bool, data jsonb, note text) as relation 16384
+var testRelation = pgoutputMessage('R', uint32(16384), "public", "wh_tasks", byte('d'), uint16(5),
+	byte(1), "id", uint32(20), uint32(0xFFFFFFFF),
+	byte(0), "name", uint32(25), uint32(0xFFFFFFFF),
+	byte(0), "done", uint32(16), uint32(0xFFFFFFFF),
+	byte(0), "data", uint32(3802), uint32(0xFFFFFFFF),
+	byte(0), "note", uint32(25), uint32(0xFFFFFFFF),
+)
+
+func TestDecodePgoutput(t *testing.T) {
+	fullRow := pgoutputTuple("42", "Boiler", "t", `{"floor": 2}`, nil)
+	keyRow := pgoutputTuple("42", nil, nil, nil, nil)
+
+	tests := []struct {
+		name      string
+		message   []byte
+		wantErr   bool
+		wantNil   bool
+		operation string
+		newData   string
+		oldData   string
+	}{
+		{
+			name:      "insert",
+			message:   pgoutputMessage('I', uint32(16384), byte('N'), fullRow),
+			operation: "INSERT",
+			newData:   `{"data":{"floor":2},"done":true,"id":42,"name":"Boiler","note":null}`,
+		},
+		{
+			name:      "update without old row",
+			message:   pgoutputMessage('U', uint32(16384), byte('N'), fullRow),
+			operation: "UPDATE",
+			newData:   `{"data":{"floor":2},"done":true,"id":42,"name":"Boiler","note":null}`,
+		},
+		{
+			name:      "update with old key",
+			message:   pgoutputMessage('U', uint32(16384), byte('K'), keyRow, byte('N'), fullRow),
+			operation: "UPDATE",
+			newData:   `{"data":{"floor":2},"done":true,"id":42,"name":"Boiler","note":null}`,
+			oldData:   `{"data":null,"done":null,"id":42,"name":null,"note":null}`,
+		},
+		{
+			name:      "update leaving out an unchanged TOAST value",
+			message:   pgoutputMessage('U', uint32(16384), byte('N'), pgoutputTuple("42", "Boiler", "f", 'u', nil)),
+			operation: "UPDATE",
+			newData:   `{"done":false,"id":42,"name":"Boiler","note":null}`,
+		},
+		{
+			name:      "delete",
+			message:   pgoutputMessage('D', uint32(16384), byte('K'), keyRow),
+			operation: "DELETE",
+			oldData:   `{"data":null,"done":null,"id":42,"name":null,"note":null}`,
+		},
+		{
+			name:    "change of an unknown relation",
+			message: pgoutputMessage('I', uint32(99), byte('N'), fullRow),
+			wantErr: true,
+		},
+		{
+			name:    "truncated tuple",
+			message: pgoutputMessage('I', uint32(16384), byte('N'), fullRow[:10]),
+			wantErr: true,
+		},
+		{
+			name:    "tuple with more columns than the relation",
+			message: pgoutputMessage('I', uint32(16384), byte('N'), pgoutputTuple("1", "a", "t", "{}", nil, "extra")),
+			wantErr: true,
+		},
+		{
+			name:    "empty message",
+			message: nil,
+			wantNil: true,
+		},
+		{
+			name:    "truncate",
+			message: pgoutputMessage('T', uint32(1), byte(0), uint32(16384)),
+			wantNil: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consumer := &replicationConsumer{relations: make(map[uint32]*pgoutputRelation)}
+			var transaction replicationTransaction
+			if _, err := consumer.decodePgoutput(testRelation, &transaction); err != nil {
+				t.Fatalf("relation: %v", err)
+			}
+
+			change, err := consumer.decodePgoutput(tt.message, &transaction)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decodePgoutput() = %+v, want an error", change)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodePgoutput() error = %v", err)
+			}
+			if tt.wantNil {
+				if change != nil {
+					t.Errorf("decodePgoutput() = %+v, want no change", change)
+				}
+				return
+			}
+			if change.Table != "wh_tasks" || change.Operation != tt.operation {
+				t.Errorf("change = %s %s, want wh_tasks %s", change.Table, change.Operation, tt.operation)
+			}
+			if string(change.NewData) != tt.newData {
+				t.Errorf("new data = %s, want %s", change.NewData, tt.newData)
+			}
+			if string(change.OldData) != tt.oldData {
+				t.Errorf("old data = %s, want %s", change.OldData, tt.oldData)
+			}
+		})
+	}
+}
+
+func TestDecodePgoutputTransaction(t *testing.T) {
+	consumer := &replicationConsumer{relations: make(map[uint32]*pgoutputRelation)}
+	var transaction replicationTransaction
+
+	// Commit 2000-01-01 00:00:01 UTC (microseconds since the PostgreSQL epoch) at LSN 1/2A
+	begin := pgoutputMessage('B', uint64(1<<32|0x2A), uint64(1_000_000), uint32(777))
+	if _, err := consumer.decodePgoutput(begin, &transaction); err != nil {
+		t.Fatal(err)
+	}
+	if transaction.XID != 777 || transaction.ID != "1/2A" || transaction.Timestamp != 946684801 {
+		t.Errorf("transaction = %+v, want xid 777, id 1/2A, timestamp 946684801", transaction)
+	}
+	if transaction.Committed {
+		t.Error("transaction committed before its commit message")
+	}
+
+	if _, err := consumer.decodePgoutput(testRelation, &transaction); err != nil {
+		t.Fatal(err)
+	}
+	change, err := consumer.decodePgoutput(pgoutputMessage('I', uint32(16384), byte('N'), pgoutputTuple("1", "a", "t", "{}", nil)), &transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Timestamp != transaction.Timestamp {
+		t.Errorf("change timestamp = %v, want the commit time %v", change.Timestamp, transaction.Timestamp)
+	}
+
+	if _, err := consumer.decodePgoutput(pgoutputMessage('C', byte(0), uint64(0), uint64(0), uint64(0)), &transaction); err != nil {
+		t.Fatal(err)
+	}
+	if !transaction.Committed {
+		t.Error("transaction not committed after its commit message")
+	}
+}
+
+func TestPgTextToJSON(t *testing.T) {
+	tests := []struct {
+		typeOID uint32
+		value   string
+		want    interface{}
+	}{
+		{16, "t", true},
+		{16, "f", false},
+		{23, "42", "42"},
+		{1700, "NaN", "NaN"},
+		{701, "-Infinity", "-Infinity"},
+		{25, "text", "text"},
+		{3802, `{"a":1}`, `{"a":1}`},
+	}
+	for _, tt := range tests {
+		got := pgTextToJSON(tt.typeOID, tt.value)
+		var text interface{} = got
+		switch value := got.(type) {
+		case interface{ String() string }:
+			text = value.String()
+		case []byte:
+			text = string(value)
+		}
+		if text != tt.want {
+			t.Errorf("pgTextToJSON(%d, %q) = %v, want %v", tt.typeOID, tt.value, got, tt.want)
+		}
+	}
+}
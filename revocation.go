@@ -0,0 +1,238 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igm/sockjs-go/v3/sockjs"
+	"github.com/lib/pq"
+)
+
+// tokenRevocationChannel receives the ids of deleted or changed Sanctum tokens
+const tokenRevocationChannel = "whagons_token_revocations"
+
+// tokenRevocationTriggerName is the trigger installed on personal_access_tokens
+const tokenRevocationTriggerName = "whagons_token_revocation_trigger"
+
+// tokenRevocationSQL installs a trigger notifying when a token is deleted or its expiry or hash
+// changes. last_used_at updates, which happen on every request, stay silent.
+var tokenRevocationSQL = []string{
+	fmt.Sprintf(`
+		CREATE OR REPLACE FUNCTION whagons_notify_token_revocation()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			IF TG_OP = 'DELETE' THEN
+				PERFORM pg_notify('%[1]s', OLD.id::text);
+				RETURN OLD;
+			END IF;
+			IF NEW.expires_at IS DISTINCT FROM OLD.expires_at OR NEW.token IS DISTINCT FROM OLD.token THEN
+				PERFORM pg_notify('%[1]s', NEW.id::text);
+			END IF;
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;`, tokenRevocationChannel),
+	fmt.Sprintf(`DROP TRIGGER IF EXISTS %s ON personal_access_tokens;`, tokenRevocationTriggerName),
+	fmt.Sprintf(`CREATE TRIGGER %s
+		AFTER UPDATE OR DELETE ON personal_access_tokens
+		FOR EACH ROW
+		EXECUTE FUNCTION whagons_notify_token_revocation();`, tokenRevocationTriggerName),
+}
+
+// setupTokenRevocation installs the revocation trigger in a tenant database and listens to it, so
+// the sessions of a token are revalidated as soon as it is deleted or changed
+func (e *RealtimeEngine) setupTokenRevocation(tenant TenantDB, db *sql.DB) error {
+	if !config.TokenRevocationNotify {
+		return nil
+	}
+
+	if config.TriggerDryRun {
+		log.Printf("📝 [dry-run] Token revocation trigger for tenant %s (database: %s):\n%s",
+			tenant.Name, tenant.Database, strings.Join(tokenRevocationSQL, "\n"))
+	} else {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, statement := range tokenRevocationSQL {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("failed to install token revocation trigger: %w", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return e.listenTenantChannel(tenant, tokenRevocationChannel, channelHandler{
+		notify: func(payload string) {
+			tokenID, err := strconv.Atoi(payload)
+			if err != nil {
+				log.Printf("⚠️  Invalid token revocation payload for %s: %q", tenant.Name, payload)
+				return
+			}
+			e.revalidateTenantSessions(tenant.Name, []int{tokenID})
+		},
+		// Revocations may have been missed meanwhile
+		reconnected: func() { e.revalidateTenantSessions(tenant.Name, nil) },
+	})
+}
+
+// revalidateSessions checks the tokens of all live sessions (call periodically)
+func (e *RealtimeEngine) revalidateSessions() {
+	e.mutex.RLock()
+	tenants := make(map[string]bool)
+	for _, authSession := range e.authenticatedSessions {
+		tenants[authSession.TenantName] = true
+	}
+	e.mutex.RUnlock()
+
+	for tenantName := range tenants {
+		e.revalidateTenantSessions(tenantName, nil)
+	}
+}
+
+// revalidateTenantSessions checks the tokens of a tenant's live sessions (only the given Sanctum
+// token ids when not nil) and expires the sessions whose token was revoked or has expired
+func (e *RealtimeEngine) revalidateTenantSessions(tenantName string, tokenIDs []int) {
+	only := make(map[int]bool, len(tokenIDs))
+	for _, tokenID := range tokenIDs {
+		only[tokenID] = true
+	}
+
+	now := time.Now()
+	e.mutex.RLock()
+	var sessions []*AuthenticatedSession
+	var expired []*AuthenticatedSession
+	for _, authSession := range e.authenticatedSessions {
+		if authSession.TenantName != tenantName {
+			continue
+		}
+		if authSession.Authenticator == "sanctum" {
+			if tokenIDs == nil || only[authSession.TokenID] {
+				sessions = append(sessions, authSession)
+			}
+		} else if tokenIDs == nil && authSession.ExpiresAt != nil && authSession.ExpiresAt.Before(now) {
+			// Other tokens (e.g. JWT) are self-contained: only their expiry can end them
+			expired = append(expired, authSession)
+		}
+	}
+	e.mutex.RUnlock()
+
+	for _, authSession := range expired {
+		expiredSession := authSession
+		e.expireSessions(tenantName, func(candidate *AuthenticatedSession) bool {
+			return candidate.TokenKey == expiredSession.TokenKey
+		}, fmt.Sprintf("%s token of user %d", expiredSession.Authenticator, expiredSession.UserID), "token expired")
+	}
+	if len(sessions) == 0 {
+		return
+	}
+
+	invalid, err := e.invalidTokens(tenantName, sessions)
+	if err != nil {
+		// Keep sessions open when the database cannot tell; the next check retries
+		log.Printf("⚠️  Failed to revalidate tokens of tenant %s: %v", tenantName, err)
+		return
+	}
+	for tokenID, reason := range invalid {
+		e.expireTokenSessions(tenantName, tokenID, reason)
+	}
+}
+
+// invalidTokens returns the tokens of the sessions that no longer exist or have expired, with the reason
+func (e *RealtimeEngine) invalidTokens(tenantName string, sessions []*AuthenticatedSession) (map[int]string, error) {
+	db, err := e.tenantDB(tenantName)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make(map[int]bool)
+	for _, authSession := range sessions {
+		pending[authSession.TokenID] = true
+	}
+	ids := make([]int64, 0, len(pending))
+	for tokenID := range pending {
+		ids = append(ids, int64(tokenID))
+	}
+
+	rows, err := db.Query(`SELECT id, expires_at FROM personal_access_tokens WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invalid := make(map[int]string)
+	now := time.Now()
+	for rows.Next() {
+		var tokenID int
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&tokenID, &expiresAt); err != nil {
+			return nil, err
+		}
+		delete(pending, tokenID)
+		if expiresAt.Valid && expiresAt.Time.Before(now) {
+			invalid[tokenID] = "token expired"
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for tokenID := range pending {
+		invalid[tokenID] = "token revoked"
+	}
+	return invalid, nil
+}
+
+// expireTokenSessions tells every session of a Sanctum token that its authentication ended,
+// closes them and drops the token from the authentication cache
+func (e *RealtimeEngine) expireTokenSessions(tenantName string, tokenID int, reason string) {
+	e.expireSessions(tenantName, func(authSession *AuthenticatedSession) bool {
+		return authSession.Authenticator == "sanctum" && authSession.TokenID == tokenID
+	}, fmt.Sprintf("Token %d", tokenID), reason)
+}
+
+// expireSessions closes the tenant's sessions whose authentication matches, telling them why, and
+// drops the matching entries from the authentication cache
+func (e *RealtimeEngine) expireSessions(tenantName string, match func(*AuthenticatedSession) bool, subject, reason string) {
+	e.mutex.Lock()
+	expired := make(map[string]sockjs.Session)
+	for sessionID, authSession := range e.authenticatedSessions {
+		if authSession.TenantName != tenantName || !match(authSession) {
+			continue
+		}
+		if session, exists := e.sessions[sessionID]; exists {
+			expired[sessionID] = session
+		} else if session, exists := e.negotiationSessions[sessionID]; exists {
+			expired[sessionID] = session
+		}
+		e.forgetSessionLocked(sessionID)
+	}
+	for cacheKey, cachedToken := range e.tokenCache {
+		if cachedToken.AuthSession.TenantName == tenantName && match(cachedToken.AuthSession) {
+			delete(e.tokenCache, cacheKey)
+		}
+	}
+	e.mutex.Unlock()
+
+	for sessionID, session := range expired {
+		e.sendToSession(session, SystemMessage{
+			Type:      "system",
+			Operation: "auth_expired",
+			Message:   fmt.Sprintf("Authentication ended: %s", reason),
+			Data:      map[string]interface{}{"reason": reason},
+			Timestamp: time.Now().Format(time.RFC3339),
+			SessionId: sessionID,
+		})
+		session.Close(4001, "Authentication expired")
+	}
+
+	log.Printf("🔐 %s of tenant %s is no longer valid (%s) - closed %d session(s)",
+		subject, tenantName, reason, len(expired))
+}
@@ -0,0 +1,53 @@
+package routes
+
+import (
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Admin API scopes granted to API keys (ADMIN_API_KEYS)
+const (
+	ScopeAll       = "*"         // every scope
+	ScopeRead      = "read"      // health, metrics and session counts
+	ScopeSessions  = "sessions"  // disconnect sessions
+	ScopeBroadcast = "broadcast" // broadcast messages
+	ScopeTenants   = "tenants"   // reload and test tenants
+)
+
+// AdminEngineInterface defines the methods we need from RealtimeEngine to authenticate admin requests
+type AdminEngineInterface interface {
+	AuthenticateAdminKey(key string) ([]string, error)
+}
+
+// requireScope only lets requests through whose API key (X-API-Key or Authorization: Bearer) has the scope
+func requireScope(engine AdminEngineInterface, scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get("X-API-Key")
+		if key == "" {
+			key = strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+		}
+
+		scopes, err := engine.AuthenticateAdminKey(key)
+		if err != nil {
+			log.Printf("🔒 Admin request %s %s rejected: %v", c.Method(), c.Path(), err)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Authentication failed",
+				"error":   err.Error(),
+			})
+		}
+
+		for _, granted := range scopes {
+			if granted == scope || granted == ScopeAll {
+				return c.Next()
+			}
+		}
+		log.Printf("🔒 Admin request %s %s rejected: API key lacks the '%s' scope", c.Method(), c.Path(), scope)
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "API key lacks the '" + scope + "' scope",
+		})
+	}
+}
@@ -14,6 +14,8 @@ import (
 type EngineInterface interface {
 	controllers.RealtimeEngineInterface
 	controllers.HealthEngineInterface
+	controllers.ChecksumEngineInterface
+	AdminEngineInterface
 }
 
 // SetupRoutes configures all API routes
@@ -21,6 +23,7 @@ func SetupRoutes(app *fiber.App, engine EngineInterface) {
 	// Create controllers
 	sessionController := controllers.NewSessionController(engine)
 	healthController := controllers.NewHealthController(engine)
+	checksumController := controllers.NewChecksumController(engine)
 
 	// Add middleware for logging, CORS, and recovery
 	setupMiddleware(app)
@@ -28,25 +31,27 @@ func SetupRoutes(app *fiber.App, engine EngineInterface) {
 	// API v1 group
 	api := app.Group("/api")
 
-	// Health endpoints
-	health := api.Group("/health")
-	health.Get("/", healthController.GetHealth)
+	// Health endpoint, open to load balancers and uptime checks
+	api.Get("/health", healthController.GetHealth)
 
 	// Metrics endpoint
-	api.Get("/metrics", healthController.GetMetrics)
+	api.Get("/metrics", requireScope(engine, ScopeRead), healthController.GetMetrics)
 
 	// Session management endpoints
 	sessions := api.Group("/sessions")
-	sessions.Get("/count", sessionController.GetSessionsCount)
-	sessions.Post("/disconnect-all", sessionController.DisconnectAllSessions)
+	sessions.Get("/count", requireScope(engine, ScopeRead), sessionController.GetSessionsCount)
+	sessions.Post("/disconnect-all", requireScope(engine, ScopeSessions), sessionController.DisconnectAllSessions)
 
 	// Tenant management endpoints
-	tenants := api.Group("/tenants")
+	tenants := api.Group("/tenants", requireScope(engine, ScopeTenants))
 	tenants.Post("/reload", sessionController.ReloadTenants)
 	tenants.Post("/test-notification", sessionController.TestTenantNotification)
 
 	// Broadcasting endpoint
-	api.Post("/broadcast", sessionController.BroadcastMessage)
+	api.Post("/broadcast", requireScope(engine, ScopeBroadcast), sessionController.BroadcastMessage)
+
+	// Integrity checksum endpoint (authenticated with the client's bearer token)
+	api.Post("/checksum", checksumController.ComputeChecksum)
 }
 
 // setupMiddleware configures middleware for the Fiber app
@@ -59,7 +64,7 @@ func setupMiddleware(app *fiber.App) {
 		return cors.New(cors.Config{
 			AllowOrigins:     "*",
 			AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS,HEAD",
-			AllowHeaders:     "Content-Type,Authorization,X-Requested-With,Accept,Origin,Cache-Control,X-File-Name",
+			AllowHeaders:     "Content-Type,Authorization,X-API-Key,X-Tenant-Domain,X-Requested-With,Accept,Origin,Cache-Control,X-File-Name",
 			AllowCredentials: false,
 			ExposeHeaders:    "Content-Length,Content-Range",
 		})(c)
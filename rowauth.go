@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy lookups of a broadcast run before its fan-out, a few at a time and within a deadline,
+// so a slow policy query delays one change instead of holding up every session in turn
+const (
+	rowPolicyPreloadWorkers = 8
+	rowPolicyPreloadTimeout = 5 * time.Second
+)
+
+// sqlRowPolicy authorizes the rows of a table by one column, whose value must be among those a
+// policy query returns for the user (e.g. the ids of the workspaces the user belongs to). The
+// query gets the user id as $1; its results are cached per tenant and user.
+type sqlRowPolicy struct {
+	engine    *RealtimeEngine
+	table     string
+	column    string
+	query     string
+	cache     map[string]*rowPolicyEntry // "tenant:user" -> values the user may see
+	lastSweep time.Time                  // when expired entries were last evicted
+	mutex     sync.Mutex
+}
+
+// rowPolicyEntry is the cached result of a policy query for one user
+type rowPolicyEntry struct {
+	allowed   map[string]bool
+	expiresAt time.Time
+}
+
+// keyOnlyDeleteWarnings holds the tables already warned about deletes withheld for lack of the old row
+var keyOnlyDeleteWarnings sync.Map
+
+// rowAuthorizerPreloader is implemented by row authorizers that look up state per user. A
+// broadcast resolves it for all its recipients up front and authorizes its fan-out with the
+// returned authorizer, which never runs a query.
+type rowAuthorizerPreloader interface {
+	preload(tenantName string, userIDs []int) RowAuthorizer
+}
+
+// CanSeeRow checks the row's policy column against the user's allowed values (implements RowAuthorizer)
+func (p *sqlRowPolicy) CanSeeRow(tenantName string, userID int, row map[string]interface{}) (bool, error) {
+	value, exists := row[p.column]
+	if !exists || value == nil {
+		return false, nil
+	}
+	allowed, err := p.allowedValues(tenantName, userID)
+	if err != nil {
+		return false, err
+	}
+	return allowed[fmt.Sprint(value)], nil
+}
+
+// allowedValues returns the cached policy result of a user, running the query when it expired
+func (p *sqlRowPolicy) allowedValues(tenantName string, userID int) (map[string]bool, error) {
+	if allowed, cached := p.cached(tenantName, userID); cached {
+		return allowed, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rowPolicyPreloadTimeout)
+	defer cancel()
+	return p.load(ctx, tenantName, userID)
+}
+
+// cached returns the policy result of a user while it has not expired
+func (p *sqlRowPolicy) cached(tenantName string, userID int) (map[string]bool, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	entry, exists := p.cache[fmt.Sprintf("%s:%d", tenantName, userID)]
+	if !exists || !time.Now().Before(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.allowed, true
+}
+
+// load runs the policy query of a user and caches its result
+func (p *sqlRowPolicy) load(ctx context.Context, tenantName string, userID int) (map[string]bool, error) {
+	db, err := p.engine.tenantDB(tenantName)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, p.query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("row policy query for %s failed: %w", p.table, err)
+	}
+	defer rows.Close()
+
+	allowed := make(map[string]bool)
+	for rows.Next() {
+		var value sql.NullString
+		if err := rows.Scan(&value); err != nil {
+			return nil, fmt.Errorf("row policy query for %s failed: %w", p.table, err)
+		}
+		if value.Valid {
+			allowed[value.String] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row policy query for %s failed: %w", p.table, err)
+	}
+
+	p.store(fmt.Sprintf("%s:%d", tenantName, userID), allowed)
+	log.Printf("🛡️  Loaded %s row policy for user %d of %s (%d allowed %s values)",
+		p.table, userID, tenantName, len(allowed), p.column)
+	return allowed, nil
+}
+
+// store caches a policy result, evicting the expired entries at most once per cache period
+func (p *sqlRowPolicy) store(cacheKey string, allowed map[string]bool) {
+	ttl := time.Duration(config.RowPolicyCacheSeconds) * time.Second
+	now := time.Now()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if now.Sub(p.lastSweep) >= ttl {
+		for key, entry := range p.cache {
+			if !now.Before(entry.expiresAt) {
+				delete(p.cache, key)
+			}
+		}
+		p.lastSweep = now
+	}
+	p.cache[cacheKey] = &rowPolicyEntry{allowed: allowed, expiresAt: now.Add(ttl)}
+}
+
+// preload resolves the policy of every given user, querying those without a cached result
+// (implements rowAuthorizerPreloader). Users whose query fails or misses the deadline see no rows.
+func (p *sqlRowPolicy) preload(tenantName string, userIDs []int) RowAuthorizer {
+	resolved := &resolvedRowPolicy{column: p.column, allowed: make(map[int]map[string]bool, len(userIDs))}
+	var missing []int
+	for _, userID := range userIDs {
+		if allowed, cached := p.cached(tenantName, userID); cached {
+			resolved.allowed[userID] = allowed
+		} else {
+			missing = append(missing, userID)
+		}
+	}
+	if len(missing) == 0 {
+		return resolved
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rowPolicyPreloadTimeout)
+	defer cancel()
+
+	userIDsToLoad := make(chan int)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for worker := 0; worker < min(rowPolicyPreloadWorkers, len(missing)); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for userID := range userIDsToLoad {
+				allowed, err := p.load(ctx, tenantName, userID)
+				if err != nil {
+					log.Printf("❌ Row policy of %s for user %d (tenant: %s) failed, rows withheld: %v",
+						p.table, userID, tenantName, err)
+					continue
+				}
+				mutex.Lock()
+				resolved.allowed[userID] = allowed
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, userID := range missing {
+		userIDsToLoad <- userID
+	}
+	close(userIDsToLoad)
+	wg.Wait()
+	return resolved
+}
+
+// resolvedRowPolicy is a SQL row policy resolved for the recipients of one broadcast
+type resolvedRowPolicy struct {
+	column  string
+	allowed map[int]map[string]bool // user id -> values the user may see, absent when the lookup failed
+}
+
+// CanSeeRow checks the row's policy column against the resolved values of the user (implements RowAuthorizer)
+func (r *resolvedRowPolicy) CanSeeRow(tenantName string, userID int, row map[string]interface{}) (bool, error) {
+	value, exists := row[r.column]
+	if !exists || value == nil {
+		return false, nil
+	}
+	// A user missing here had a failing lookup, already logged by preload
+	return r.allowed[userID][fmt.Sprint(value)], nil
+}
+
+// parseRowPolicies reads ROW_POLICIES: a JSON array of "table.column=SELECT ... WHERE user_id = $1"
+// entries, or a single entry. Entries are never split on ";", which may appear in a query.
+func parseRowPolicies(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	if !strings.HasPrefix(value, "[") {
+		return []string{value}
+	}
+	var policies []string
+	if err := json.Unmarshal([]byte(value), &policies); err != nil {
+		log.Printf("⚠️  Ignoring ROW_POLICIES, not a JSON array of policies: %v", err)
+		return nil
+	}
+	return policies
+}
+
+// registerRowPolicies registers a SQL row policy for every ROW_POLICIES entry
+func (e *RealtimeEngine) registerRowPolicies() {
+	for _, entry := range config.RowPolicies {
+		target, query, found := strings.Cut(entry, "=")
+		target, query = strings.TrimSpace(target), strings.TrimSpace(query)
+		if !found || target == "" || query == "" {
+			continue
+		}
+
+		table, column, found := strings.Cut(target, ".")
+		if !found || !identifierPattern.MatchString(table) || !identifierPattern.MatchString(column) {
+			log.Printf("⚠️  Ignoring row policy with invalid target %q (expected table.column)", target)
+			continue
+		}
+
+		e.RegisterRowAuthorizer(table, &sqlRowPolicy{
+			engine: e,
+			table:  table,
+			column: column,
+			query:  query,
+			cache:  make(map[string]*rowPolicyEntry),
+		})
+		log.Printf("🛡️  Row policy registered for %s (by %s)", table, column)
+	}
+}
+
+// RegisterRowAuthorizer registers the row authorizer of a table, replacing any existing one
+func (e *RealtimeEngine) RegisterRowAuthorizer(table string, authorizer RowAuthorizer) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.rowAuthorizers[table] = authorizer
+}
+
+// rowAuthorizer returns the row authorizer of a table, nil when every row is visible
+func (e *RealtimeEngine) rowAuthorizer(table string) RowAuthorizer {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.rowAuthorizers[table]
+}
+
+// canSeeRow asks an authorizer whether a session's user may see a row; errors deny the row
+func canSeeRow(authorizer RowAuthorizer, authSession *AuthenticatedSession, table string, values map[string]interface{}) bool {
+	if authorizer == nil {
+		return true
+	}
+	visible, err := authorizer.CanSeeRow(authSession.TenantName, authSession.UserID, values)
+	if err != nil {
+		log.Printf("❌ Row authorization of %s for user %d (tenant: %s) failed, row withheld: %v",
+			table, authSession.UserID, authSession.TenantName, err)
+		return false
+	}
+	return visible
+}
+
+// authorizeMessage applies a table's row authorizer to a message routed to a session. An UPDATE
+// moving a row out of the user's sight becomes a LEAVE.
+func authorizeMessage(authorizer RowAuthorizer, authSession *AuthenticatedSession, message PublicationMessage, newValues, oldValues map[string]interface{}) (PublicationMessage, bool) {
+	if authorizer == nil {
+		return message, true
+	}
+
+	switch message.Operation {
+	case "INSERT":
+		return message, canSeeRow(authorizer, authSession, message.Table, newValues)
+	case "DELETE", "LEAVE":
+		// Without the old row (e.g. replication without REPLICA IDENTITY FULL) only the key is
+		// known: nothing tells who saw the row, and its id would reveal a hidden row to the others
+		if len(oldValues) <= 1 {
+			if _, warned := keyOnlyDeleteWarnings.LoadOrStore(message.Table, true); !warned {
+				log.Printf("⚠️  %s of %s without the old row withheld by its row policy (set REPLICA IDENTITY FULL on the table)",
+					message.Operation, message.Table)
+			}
+			return message, false
+		}
+		return message, canSeeRow(authorizer, authSession, message.Table, oldValues)
+	case "UPDATE":
+		if canSeeRow(authorizer, authSession, message.Table, newValues) {
+			return message, true
+		}
+		if len(oldValues) > 1 && canSeeRow(authorizer, authSession, message.Table, oldValues) {
+			return leaveMessage(message, "is no longer visible to you"), true
+		}
+	}
+	return message, false
+}
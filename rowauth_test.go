@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAuthorizeMessage(t *testing.T) {
+	authorizer := &resolvedRowPolicy{
+		column:  "workspace_id",
+		allowed: map[int]map[string]bool{1: {"10": true}},
+	}
+	visible := map[string]interface{}{"id": 5, "workspace_id": 10}
+	hidden := map[string]interface{}{"id": 5, "workspace_id": 20}
+	keyOnly := map[string]interface{}{"id": 5}
+
+	tests := []struct {
+		name          string
+		userID        int
+		operation     string
+		newValues     map[string]interface{}
+		oldValues     map[string]interface{}
+		wantDeliver   bool
+		wantOperation string
+	}{
+		{"insert of a visible row", 1, "INSERT", visible, nil, true, "INSERT"},
+		{"insert of a hidden row", 1, "INSERT", hidden, nil, false, ""},
+		{"update staying visible", 1, "UPDATE", visible, visible, true, "UPDATE"},
+		{"update moving out of sight", 1, "UPDATE", hidden, visible, true, "LEAVE"},
+		{"update of a hidden row", 1, "UPDATE", hidden, hidden, false, ""},
+		{"update moving into sight", 1, "UPDATE", visible, hidden, true, "UPDATE"},
+		{"delete of a visible row", 1, "DELETE", nil, visible, true, "DELETE"},
+		{"delete of a hidden row", 1, "DELETE", nil, hidden, false, ""},
+		{"delete with only the key", 1, "DELETE", nil, keyOnly, false, ""},
+		{"leave with only the key", 1, "LEAVE", nil, keyOnly, false, ""},
+		{"user whose policy failed to load", 2, "INSERT", visible, nil, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := PublicationMessage{Table: "wh_tasks", Operation: tt.operation, NewData: tt.newValues, OldData: tt.oldValues}
+			authSession := &AuthenticatedSession{TenantName: "acme", UserID: tt.userID}
+
+			got, deliver := authorizeMessage(authorizer, authSession, message, tt.newValues, tt.oldValues)
+			if deliver != tt.wantDeliver {
+				t.Fatalf("deliver = %v, want %v", deliver, tt.wantDeliver)
+			}
+			if deliver && got.Operation != tt.wantOperation {
+				t.Errorf("operation = %s, want %s", got.Operation, tt.wantOperation)
+			}
+			if deliver && got.Operation == "LEAVE" && got.NewData != nil {
+				t.Errorf("LEAVE carries new data: %v", got.NewData)
+			}
+		})
+	}
+}
+
+func TestSQLRowPolicyPreloadUsesCache(t *testing.T) {
+	policy := &sqlRowPolicy{engine: newTestEngine(), table: "wh_tasks", column: "workspace_id", cache: make(map[string]*rowPolicyEntry)}
+	policy.store("acme:1", map[string]bool{"10": true})
+
+	// User 2 is not cached and the tenant has no database, so its lookup fails
+	resolved := policy.preload("acme", []int{1, 2})
+	for _, tt := range []struct {
+		userID int
+		want   bool
+	}{{1, true}, {2, false}} {
+		visible, err := resolved.CanSeeRow("acme", tt.userID, map[string]interface{}{"workspace_id": 10})
+		if err != nil || visible != tt.want {
+			t.Errorf("user %d: CanSeeRow = %v, %v; want %v", tt.userID, visible, err, tt.want)
+		}
+	}
+}
+
+func TestSQLRowPolicyEvictsExpiredEntries(t *testing.T) {
+	policy := &sqlRowPolicy{cache: make(map[string]*rowPolicyEntry)}
+	policy.cache["acme:1"] = &rowPolicyEntry{expiresAt: time.Now().Add(-time.Second)}
+	policy.cache["acme:2"] = &rowPolicyEntry{expiresAt: time.Now().Add(time.Hour)}
+
+	policy.store("acme:3", map[string]bool{})
+
+	if _, exists := policy.cache["acme:1"]; exists {
+		t.Error("expired entry was not evicted")
+	}
+	for _, key := range []string{"acme:2", "acme:3"} {
+		if _, exists := policy.cache[key]; !exists {
+			t.Errorf("entry %s was evicted", key)
+		}
+	}
+}
+
+func TestParseRowPolicies(t *testing.T) {
+	output := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(output)
+
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{name: "unset", value: "", want: nil},
+		{name: "single policy", value: "wh_tasks.workspace_id=SELECT workspace_id FROM wh_workspace_user WHERE user_id = $1", want: []string{"wh_tasks.workspace_id=SELECT workspace_id FROM wh_workspace_user WHERE user_id = $1"}},
+		{name: "single policy keeps its semicolons", value: "wh_tasks.status=SELECT status FROM s WHERE user_id = $1 AND label <> 'a;b'", want: []string{"wh_tasks.status=SELECT status FROM s WHERE user_id = $1 AND label <> 'a;b'"}},
+		{
+			name:  "JSON array",
+			value: `["wh_tasks.workspace_id=SELECT workspace_id FROM wh_workspace_user WHERE user_id = $1", "wh_notes.team_id=SELECT team_id FROM t WHERE user_id = $1 AND note <> ';'"]`,
+			want:  []string{"wh_tasks.workspace_id=SELECT workspace_id FROM wh_workspace_user WHERE user_id = $1", "wh_notes.team_id=SELECT team_id FROM t WHERE user_id = $1 AND note <> ';'"},
+		},
+		{name: "malformed JSON array", value: `["wh_tasks.workspace_id=SELECT 1"`, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRowPolicies(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRowPolicies(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
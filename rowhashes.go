@@ -0,0 +1,168 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// rowHashTriggerName is the trigger maintaining row_hashes on each tracked table
+const rowHashTriggerName = "whagons_row_hash_trigger"
+
+// setupTenantRowHashes creates the row_hashes table and trigger function in a tenant database
+// and attaches the trigger to every configured table, backfilling hashes on first install
+func (e *RealtimeEngine) setupTenantRowHashes(tenantName string, db *sql.DB) error {
+	tables := parseList(config.RowHashTables)
+	if len(tables) == 0 {
+		return nil
+	}
+
+	log.Printf("🔧 Setting up row hashes for tenant %s...", tenantName)
+
+	createTableSQL := `
+		CREATE TABLE IF NOT EXISTS row_hashes (
+			table_name TEXT NOT NULL,
+			row_id BIGINT NOT NULL,
+			row_hash TEXT NOT NULL,
+			last_updated TIMESTAMP WITH TIME ZONE,
+			PRIMARY KEY (table_name, row_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_row_hashes_last_updated ON row_hashes (table_name, last_updated DESC);`
+
+	if _, err := db.Exec(createTableSQL); err != nil {
+		return fmt.Errorf("failed to create row_hashes table: %w", err)
+	}
+
+	// Trigger arguments: the updated column, then the hashed columns in order.
+	// The hash must stay identical to rowHashExpression so both checksum paths agree.
+	createFunctionSQL := `
+		CREATE OR REPLACE FUNCTION whagons_update_row_hash()
+		RETURNS TRIGGER AS $$
+		DECLARE
+			row_data JSONB;
+			parts TEXT[] := '{}';
+			i INTEGER;
+		BEGIN
+			IF TG_OP = 'DELETE' THEN
+				DELETE FROM row_hashes WHERE table_name = TG_TABLE_NAME AND row_id = OLD.id;
+				RETURN OLD;
+			END IF;
+			IF TG_OP = 'UPDATE' THEN
+				IF OLD.id <> NEW.id THEN
+					DELETE FROM row_hashes WHERE table_name = TG_TABLE_NAME AND row_id = OLD.id;
+				END IF;
+			END IF;
+
+			row_data := to_jsonb(NEW);
+			FOR i IN 1..TG_NARGS - 1 LOOP
+				parts := parts || coalesce(row_data->>TG_ARGV[i], '');
+			END LOOP;
+
+			INSERT INTO row_hashes (table_name, row_id, row_hash, last_updated)
+			VALUES (TG_TABLE_NAME, NEW.id, md5(array_to_string(parts, '|')), (row_data->>TG_ARGV[0])::timestamptz)
+			ON CONFLICT (table_name, row_id)
+			DO UPDATE SET row_hash = EXCLUDED.row_hash, last_updated = EXCLUDED.last_updated;
+
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;`
+
+	if _, err := db.Exec(createFunctionSQL); err != nil {
+		return fmt.Errorf("failed to create row hash function: %w", err)
+	}
+
+	installed := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		if err := e.installRowHashTrigger(db, table); err != nil {
+			log.Printf("⚠️  Row hashes disabled for %s.%s: %v", tenantName, table, err)
+			continue
+		}
+		installed[table] = true
+	}
+
+	e.mutex.Lock()
+	e.rowHashTables[tenantName] = installed
+	e.mutex.Unlock()
+
+	log.Printf("✅ Row hashes maintained for %d/%d table(s) of tenant %s", len(installed), len(tables), tenantName)
+	return nil
+}
+
+// installRowHashTrigger attaches the row hash trigger to a table. When the trigger is new or its
+// columns changed, the table's hashes are rebuilt in the same transaction, so no write is missed.
+func (e *RealtimeEngine) installRowHashTrigger(db *sql.DB, table string) error {
+	if !identifierPattern.MatchString(table) {
+		return fmt.Errorf("invalid table name: %s", table)
+	}
+	columns, err := checksumColumns(table)
+	if err != nil {
+		return err
+	}
+	updatedColumn := config.ChecksumUpdatedColumn
+	triggerArgs := append([]string{updatedColumn}, columns...)
+
+	// pg_trigger stores the arguments NUL-separated; an identical trigger needs no rebuild
+	var existingArgs sql.NullString
+	err = db.QueryRow(`SELECT encode(tgargs, 'escape') FROM pg_trigger WHERE tgname = $1 AND tgrelid = $2::regclass`,
+		rowHashTriggerName, table).Scan(&existingArgs)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to inspect trigger: %w", err)
+	}
+	if existingArgs.Valid && existingArgs.String == strings.Join(triggerArgs, `\000`)+`\000` {
+		return nil
+	}
+
+	quotedArgs := make([]string, len(triggerArgs))
+	for i, arg := range triggerArgs {
+		quotedArgs[i] = "'" + arg + "'"
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS %s ON %s`, rowHashTriggerName, table)); err != nil {
+		return fmt.Errorf("failed to drop existing trigger: %w", err)
+	}
+
+	createTriggerSQL := fmt.Sprintf(`
+		CREATE TRIGGER %s
+			AFTER INSERT OR UPDATE OR DELETE
+			ON %s
+			FOR EACH ROW
+			EXECUTE FUNCTION whagons_update_row_hash(%s)`, rowHashTriggerName, table, strings.Join(quotedArgs, ", "))
+	if _, err := tx.Exec(createTriggerSQL); err != nil {
+		return fmt.Errorf("failed to create trigger: %w", err)
+	}
+
+	// Backfill: drop every hash of the table and hash the current rows
+	if _, err := tx.Exec(`DELETE FROM row_hashes WHERE table_name = $1`, table); err != nil {
+		return fmt.Errorf("failed to clear row hashes: %w", err)
+	}
+	backfillSQL := fmt.Sprintf(`
+		INSERT INTO row_hashes (table_name, row_id, row_hash, last_updated)
+		SELECT $1, t.id, %[1]s, (to_jsonb(t)->>'%[2]s')::timestamptz
+		FROM %[3]s t`, rowHashExpression("to_jsonb(t)", columns), updatedColumn, table)
+	result, err := tx.Exec(backfillSQL, table)
+	if err != nil {
+		return fmt.Errorf("failed to backfill row hashes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	backfilled, _ := result.RowsAffected()
+	log.Printf("🧮 Row hash trigger installed on %s (%d rows hashed)", table, backfilled)
+	return nil
+}
+
+// hasRowHashes checks whether row_hashes is maintained for a tenant table
+func (e *RealtimeEngine) hasRowHashes(tenantName, table string) bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.rowHashTables[tenantName][table]
+}
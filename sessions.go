@@ -0,0 +1,188 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/igm/sockjs-go/v3/sockjs"
+)
+
+// sessionEntry is what the fan-out needs about an active session
+type sessionEntry struct {
+	id            string
+	session       sockjs.Session
+	auth          *AuthenticatedSession
+	subscriptions *SessionSubscriptions // nil for legacy sessions receiving every table
+	outbound      *outboundQueue
+}
+
+// tenantSessions indexes the active sessions of one tenant by session and by user. It has its
+// own lock, so a change only touches the sessions of its tenant and never the engine mutex.
+type tenantSessions struct {
+	sessions map[string]*sessionEntry
+	users    map[int]map[string]*sessionEntry
+	mutex    sync.RWMutex
+}
+
+// tenantIndex returns the session index of a tenant, optionally creating it
+func (e *RealtimeEngine) tenantIndex(tenantName string, create bool) *tenantSessions {
+	e.tenantSessionsMutex.RLock()
+	index, exists := e.tenantSessions[tenantName]
+	e.tenantSessionsMutex.RUnlock()
+	if exists || !create {
+		return index
+	}
+
+	e.tenantSessionsMutex.Lock()
+	defer e.tenantSessionsMutex.Unlock()
+	if index, exists = e.tenantSessions[tenantName]; !exists {
+		index = &tenantSessions{
+			sessions: make(map[string]*sessionEntry),
+			users:    make(map[int]map[string]*sessionEntry),
+		}
+		e.tenantSessions[tenantName] = index
+	}
+	return index
+}
+
+// indexSessionLocked adds a promoted session to its tenant's index and gives it a send queue;
+// the caller holds e.mutex
+func (e *RealtimeEngine) indexSessionLocked(session sockjs.Session, authSession *AuthenticatedSession) {
+	outbound := e.newOutboundQueue(session.ID(), session)
+	e.outboundQueues[session.ID()] = outbound
+
+	entry := &sessionEntry{
+		id:            session.ID(),
+		session:       session,
+		auth:          authSession,
+		subscriptions: e.subscriptions[session.ID()],
+		outbound:      outbound,
+	}
+
+	index := e.tenantIndex(authSession.TenantName, true)
+	index.mutex.Lock()
+	index.sessions[entry.id] = entry
+	if index.users[authSession.UserID] == nil {
+		index.users[authSession.UserID] = make(map[string]*sessionEntry)
+	}
+	index.users[authSession.UserID][entry.id] = entry
+	index.mutex.Unlock()
+}
+
+// unindexSession removes a session from its tenant's index, dropping indexes left empty
+func (e *RealtimeEngine) unindexSession(tenantName, sessionID string) {
+	index := e.tenantIndex(tenantName, false)
+	if index == nil {
+		return
+	}
+
+	index.mutex.Lock()
+	if entry, exists := index.sessions[sessionID]; exists {
+		delete(index.sessions, sessionID)
+		delete(index.users[entry.auth.UserID], sessionID)
+		if len(index.users[entry.auth.UserID]) == 0 {
+			delete(index.users, entry.auth.UserID)
+		}
+	}
+	empty := len(index.sessions) == 0
+	index.mutex.Unlock()
+
+	if empty {
+		e.tenantSessionsMutex.Lock()
+		// Re-check under the registry lock, a session may have joined meanwhile
+		index.mutex.RLock()
+		if len(index.sessions) == 0 && e.tenantSessions[tenantName] == index {
+			delete(e.tenantSessions, tenantName)
+		}
+		index.mutex.RUnlock()
+		e.tenantSessionsMutex.Unlock()
+	}
+}
+
+// setIndexedSubscriptions records a session's subscriptions in its tenant's index
+func (e *RealtimeEngine) setIndexedSubscriptions(tenantName, sessionID string, subscriptions *SessionSubscriptions) {
+	index := e.tenantIndex(tenantName, false)
+	if index == nil {
+		return
+	}
+	index.mutex.Lock()
+	if entry, exists := index.sessions[sessionID]; exists {
+		entry.subscriptions = subscriptions
+	}
+	index.mutex.Unlock()
+}
+
+// setIndexedAuth records a session's rotated authentication in its tenant's index
+func (e *RealtimeEngine) setIndexedAuth(tenantName, sessionID string, authSession *AuthenticatedSession) {
+	index := e.tenantIndex(tenantName, false)
+	if index == nil {
+		return
+	}
+	index.mutex.Lock()
+	if entry, exists := index.sessions[sessionID]; exists {
+		entry.auth = authSession
+	}
+	index.mutex.Unlock()
+}
+
+// tenantSessionList returns a copy of the active sessions of a tenant
+func (e *RealtimeEngine) tenantSessionList(tenantName string) []sessionEntry {
+	index := e.tenantIndex(tenantName, false)
+	if index == nil {
+		return nil
+	}
+
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	entries := make([]sessionEntry, 0, len(index.sessions))
+	for _, entry := range index.sessions {
+		entries = append(entries, *entry)
+	}
+	return entries
+}
+
+// userSessionList returns a copy of the active sessions of one user of a tenant
+func (e *RealtimeEngine) userSessionList(tenantName string, userID int) []sessionEntry {
+	index := e.tenantIndex(tenantName, false)
+	if index == nil {
+		return nil
+	}
+
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	entries := make([]sessionEntry, 0, len(index.users[userID]))
+	for _, entry := range index.users[userID] {
+		entries = append(entries, *entry)
+	}
+	return entries
+}
+
+// forgetSessionLocked removes a session from every tracking map and index and closes its send
+// queue; the caller holds e.mutex
+func (e *RealtimeEngine) forgetSessionLocked(sessionID string) {
+	if authSession, exists := e.authenticatedSessions[sessionID]; exists {
+		e.unindexSession(authSession.TenantName, sessionID)
+	}
+	delete(e.sessions, sessionID)
+	delete(e.negotiationSessions, sessionID)
+	delete(e.authenticatedSessions, sessionID)
+	delete(e.subscriptions, sessionID)
+	e.removeOutboundQueueLocked(sessionID)
+}
+
+// GetTenantSessionCounts returns the number of active sessions per tenant
+func (e *RealtimeEngine) GetTenantSessionCounts() map[string]int {
+	e.tenantSessionsMutex.RLock()
+	indexes := make(map[string]*tenantSessions, len(e.tenantSessions))
+	for tenantName, index := range e.tenantSessions {
+		indexes[tenantName] = index
+	}
+	e.tenantSessionsMutex.RUnlock()
+
+	counts := make(map[string]int, len(indexes))
+	for tenantName, index := range indexes {
+		index.mutex.RLock()
+		counts[tenantName] = len(index.sessions)
+		index.mutex.RUnlock()
+	}
+	return counts
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/igm/sockjs-go/v3/sockjs"
+)
+
+// snapshotKeyColumn is the column used for keyset pagination of snapshots
+const snapshotKeyColumn = "id"
+
+// isSnapshotTable tells whether clients may read a table directly (snapshot, checksum): the tables
+// of SNAPSHOT_TABLES, or when unset the tracked tables and those with a registered row decoder.
+// Everything else in the tenant database (users, tokens, side tables) stays out of reach.
+func (e *RealtimeEngine) isSnapshotTable(table string) bool {
+	if config.SnapshotTables != "" {
+		return slices.Contains(parseList(config.SnapshotTables), table)
+	}
+	if slices.Contains(parseList(config.TrackedTables), table) {
+		return true
+	}
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	_, decoded := e.rowDecoders[table]
+	return decoded
+}
+
+// handleSnapshot streams a table's rows to the client in keyset-paginated chunks.
+// The snapshot is read in a single REPEATABLE READ transaction and tagged with the
+// stream sequence taken once the transaction's snapshot is established, so live changes
+// with a higher seq can be applied on top of it. Its messages take the session's send
+// queue, in order with the live changes.
+func (e *RealtimeEngine) handleSnapshot(session sockjs.Session, authSession *AuthenticatedSession, command ClientCommand) error {
+	if !identifierPattern.MatchString(command.Table) {
+		return e.sendCommandError(session, command, fmt.Sprintf("Invalid table name: %s", command.Table))
+	}
+
+	if !e.isSnapshotTable(command.Table) {
+		return e.sendCommandError(session, command, fmt.Sprintf("Table %s is not available for snapshots", command.Table))
+	}
+
+	if !authSession.canReadTable(command.Table) {
+		return e.sendCommandError(session, command, fmt.Sprintf("Missing ability to read %s", command.Table))
+	}
+
+	pageSize := command.PageSize
+	if pageSize <= 0 {
+		pageSize = config.SnapshotPageSize
+	}
+	if pageSize > config.SnapshotMaxPageSize {
+		pageSize = config.SnapshotMaxPageSize
+	}
+
+	db, err := e.tenantDB(authSession.TenantName)
+	if err != nil {
+		return e.sendCommandError(session, command, "Tenant database not available")
+	}
+
+	// Rows outside the session's filter for this table are left out
+	e.mutex.RLock()
+	subscriptions := e.subscriptions[session.ID()]
+	outbound := e.outboundQueues[session.ID()]
+	e.mutex.RUnlock()
+	if outbound == nil {
+		return e.sendCommandError(session, command, "Session is not active")
+	}
+	subscription, _ := subscriptions.subscriptionFor(command.Table)
+	if subscription == nil {
+		subscription = &TableSubscription{Table: command.Table}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.SnapshotTimeoutSeconds)*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		log.Printf("❌ Failed to start snapshot of %s for session %s: %v", command.Table, session.ID(), err)
+		return e.sendCommandError(session, command, "Failed to start snapshot")
+	}
+	defer tx.Rollback()
+
+	// The first read fixes the transaction's snapshot; the stream position is taken right after it
+	var lsn sql.NullString
+	if err := tx.QueryRowContext(ctx, "SELECT CASE WHEN pg_is_in_recovery() THEN NULL ELSE pg_current_wal_lsn()::text END").Scan(&lsn); err != nil {
+		log.Printf("❌ Failed to start snapshot of %s for session %s: %v", command.Table, session.ID(), err)
+		return e.sendCommandError(session, command, "Failed to start snapshot")
+	}
+	epoch, sequence := e.getTenantStream(authSession.TenantName).Position()
+
+	base := SnapshotMessage{
+		Type:       "snapshot",
+		RequestID:  command.RequestID,
+		TenantName: authSession.TenantName,
+		Table:      command.Table,
+		Epoch:      epoch,
+		Sequence:   sequence,
+		LSN:        lsn.String,
+	}
+
+	started := base
+	started.Operation = "started"
+	started.Timestamp = time.Now().Format(time.RFC3339)
+	if !outbound.enqueueJSON(started) {
+		return nil
+	}
+
+	log.Printf("📸 Snapshot of %s.%s started for session %s (seq %d, lsn %s, page size %d)",
+		authSession.TenantName, command.Table, session.ID(), sequence, lsn.String, pageSize)
+
+	cursor := command.After
+	totalRows := 0
+	for page := 1; ; page++ {
+		rows, lastKey, scanned, err := e.readSnapshotPage(ctx, tx, command.Table, cursor, pageSize, subscription, authSession)
+		if err != nil {
+			log.Printf("❌ Snapshot of %s failed for session %s: %v", command.Table, session.ID(), err)
+			failed := base
+			failed.Operation = "error"
+			failed.Cursor = cursor
+			failed.Message = "Snapshot failed - retry with the last cursor as 'after'"
+			failed.Timestamp = time.Now().Format(time.RFC3339)
+			outbound.enqueueJSON(failed)
+			return nil
+		}
+		if scanned == 0 {
+			break
+		}
+		cursor = lastKey
+
+		if len(rows) > 0 {
+			chunk := base
+			chunk.Operation = "chunk"
+			chunk.Rows = rows
+			chunk.Page = page
+			chunk.Cursor = cursor
+			chunk.Timestamp = time.Now().Format(time.RFC3339)
+			if !outbound.enqueueJSON(chunk) {
+				// The session is gone or was evicted
+				return nil
+			}
+			totalRows += len(rows)
+		}
+
+		if scanned < pageSize {
+			break
+		}
+	}
+
+	complete := base
+	complete.Operation = "complete"
+	complete.Cursor = cursor
+	complete.TotalRows = totalRows
+	complete.Timestamp = time.Now().Format(time.RFC3339)
+
+	log.Printf("📸 Snapshot of %s.%s complete for session %s (%d rows)",
+		authSession.TenantName, command.Table, session.ID(), totalRows)
+	outbound.enqueueJSON(complete)
+	return nil
+}
+
+// readSnapshotPage reads the next page after a key. Returns the rows passing the subscription
+// filter and the table's row policy, the last key read and the number of rows scanned.
+func (e *RealtimeEngine) readSnapshotPage(ctx context.Context, tx *sql.Tx, table, after string, pageSize int, subscription *TableSubscription, authSession *AuthenticatedSession) ([]interface{}, string, int, error) {
+	authorizer := e.rowAuthorizer(table)
+
+	var rows *sql.Rows
+	var err error
+	if after == "" {
+		query := fmt.Sprintf(`SELECT t.%[1]s::text, row_to_json(t)::text FROM %[2]s t ORDER BY t.%[1]s LIMIT $1`,
+			snapshotKeyColumn, table)
+		rows, err = tx.QueryContext(ctx, query, pageSize)
+	} else {
+		query := fmt.Sprintf(`SELECT t.%[1]s::text, row_to_json(t)::text FROM %[2]s t WHERE t.%[1]s > $1 ORDER BY t.%[1]s LIMIT $2`,
+			snapshotKeyColumn, table)
+		rows, err = tx.QueryContext(ctx, query, after, pageSize)
+	}
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer rows.Close()
+
+	var result []interface{}
+	var lastKey string
+	scanned := 0
+	for rows.Next() {
+		var key, rowJSON string
+		if err := rows.Scan(&key, &rowJSON); err != nil {
+			return nil, "", 0, err
+		}
+		lastKey = key
+		scanned++
+
+		row, err := e.decodeRow(table, []byte(rowJSON))
+		if err != nil {
+			return nil, "", 0, fmt.Errorf("failed to decode row %s: %w", key, err)
+		}
+		var values map[string]interface{}
+		if len(subscription.allowed) > 0 || authorizer != nil {
+			values = rowValues(row)
+		}
+		if len(subscription.allowed) > 0 && !subscription.matches(values) {
+			continue
+		}
+		if !canSeeRow(authorizer, authSession, table, values) {
+			continue
+		}
+		result = append(result, row)
+	}
+	return result, lastKey, scanned, rows.Err()
+}
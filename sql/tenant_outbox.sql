@@ -0,0 +1,40 @@
+-- Outbox table for the "outbox" change source (CHANGE_SOURCE=outbox)
+-- Run this on each tenant database that should be polled instead of using LISTEN/NOTIFY.
+-- whagonsRLE reads rows in id order, broadcasts them and deletes them afterwards.
+
+CREATE TABLE IF NOT EXISTS whagons_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    table_name TEXT NOT NULL,
+    operation TEXT NOT NULL,
+    new_data JSONB,
+    old_data JSONB,
+    txid BIGINT DEFAULT txid_current(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Tables created before transaction batching: the writing transaction groups changes (TX_BATCHING)
+ALTER TABLE whagons_outbox ADD COLUMN IF NOT EXISTS txid BIGINT DEFAULT txid_current();
+
+-- Trigger function writing every change to the outbox
+CREATE OR REPLACE FUNCTION whagons_outbox_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO whagons_outbox (table_name, operation, new_data, old_data)
+    VALUES (
+        TG_TABLE_NAME,
+        TG_OP,
+        CASE WHEN TG_OP = 'DELETE' THEN NULL ELSE to_jsonb(NEW) END,
+        CASE WHEN TG_OP = 'INSERT' THEN NULL ELSE to_jsonb(OLD) END
+    );
+
+    RETURN COALESCE(NEW, OLD);
+END;
+$$ LANGUAGE plpgsql;
+
+-- Attach it to each synced table, e.g. wh_tasks
+DROP TRIGGER IF EXISTS wh_tasks_outbox_trigger ON wh_tasks;
+CREATE TRIGGER wh_tasks_outbox_trigger
+    AFTER INSERT OR UPDATE OR DELETE
+    ON wh_tasks
+    FOR EACH ROW
+    EXECUTE FUNCTION whagons_outbox_changes();
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// channelHandler handles the notifications of one engine channel of a tenant
+type channelHandler struct {
+	notify      func(payload string)
+	reconnected func() // catches up after a reconnect, nil when missed notifications need nothing
+}
+
+// tenantListener is the one LISTEN connection of a tenant, shared by every engine channel
+// (token revocations, user notifications)
+type tenantListener struct {
+	database string
+	listener *pq.Listener
+	handlers map[string]channelHandler // channel -> handler
+	mutex    sync.RWMutex
+}
+
+// listenTenantChannel subscribes a handler to a channel of a tenant on the tenant's shared listener,
+// opening the listener on first use or when the tenant moved to another database
+func (e *RealtimeEngine) listenTenantChannel(tenant TenantDB, channel string, handler channelHandler) error {
+	e.mutex.Lock()
+	shared, exists := e.tenantListeners[tenant.Name]
+	if !exists || shared.database != tenant.Database {
+		previous := shared
+		shared = e.newTenantListener(tenant)
+		if previous != nil {
+			// Keep the channels of the previous database
+			previous.mutex.RLock()
+			for previousChannel, previousHandler := range previous.handlers {
+				shared.handlers[previousChannel] = previousHandler
+			}
+			previous.mutex.RUnlock()
+			previous.listener.Close()
+		}
+		e.tenantListeners[tenant.Name] = shared
+		go e.dispatchTenantNotifications(tenant.Name, shared)
+	}
+
+	shared.mutex.Lock()
+	shared.handlers[channel] = handler
+	channels := make([]string, 0, len(shared.handlers))
+	for listenedChannel := range shared.handlers {
+		channels = append(channels, listenedChannel)
+	}
+	shared.mutex.Unlock()
+	e.mutex.Unlock()
+
+	for _, listenedChannel := range channels {
+		if err := shared.listener.Listen(listenedChannel); err != nil && !errors.Is(err, pq.ErrChannelAlreadyOpen) {
+			if listenedChannel == channel {
+				shared.mutex.Lock()
+				delete(shared.handlers, channel)
+				shared.mutex.Unlock()
+			}
+			return fmt.Errorf("failed to listen to channel %s: %w", listenedChannel, err)
+		}
+	}
+
+	log.Printf("✅ Listening to channel '%s' for tenant: %s", channel, tenant.Name)
+	return nil
+}
+
+// newTenantListener opens the LISTEN connection of a tenant database
+func (e *RealtimeEngine) newTenantListener(tenant TenantDB) *tenantListener {
+	listener := pq.NewListener(
+		fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+			config.DBHost, config.DBPort, config.DBUsername, config.DBPassword, tenant.Database),
+		10*time.Second,
+		time.Minute,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("❌ Tenant listener error for %s: %v", tenant.Name, err)
+			}
+		})
+
+	return &tenantListener{
+		database: tenant.Database,
+		listener: listener,
+		handlers: make(map[string]channelHandler),
+	}
+}
+
+// dispatchTenantNotifications hands each notification of a tenant to the handler of its channel
+// until the listener is closed
+func (e *RealtimeEngine) dispatchTenantNotifications(tenantName string, shared *tenantListener) {
+	for {
+		select {
+		case notification, open := <-shared.listener.Notify:
+			if !open {
+				return
+			}
+
+			shared.mutex.RLock()
+			handlers := make(map[string]channelHandler, len(shared.handlers))
+			for channel, handler := range shared.handlers {
+				handlers[channel] = handler
+			}
+			shared.mutex.RUnlock()
+
+			if notification == nil {
+				// Reconnected: notifications may have been missed meanwhile
+				for _, handler := range handlers {
+					if handler.reconnected != nil {
+						handler.reconnected()
+					}
+				}
+				continue
+			}
+			handler, exists := handlers[notification.Channel]
+			if !exists {
+				log.Printf("⚠️  Notification on unhandled channel %s for tenant %s", notification.Channel, tenantName)
+				continue
+			}
+			handler.notify(notification.Extra)
+		case <-time.After(90 * time.Second):
+			go shared.listener.Ping()
+		}
+	}
+}
+
+// stopTenantListener closes the shared listener of a tenant
+func (e *RealtimeEngine) stopTenantListener(tenantName string) {
+	e.mutex.Lock()
+	shared, exists := e.tenantListeners[tenantName]
+	delete(e.tenantListeners, tenantName)
+	e.mutex.Unlock()
+
+	if exists {
+		shared.listener.Close()
+	}
+}
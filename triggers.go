@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// changeTriggerName is the notify trigger installed on each tracked table
+const changeTriggerName = "whagons_changes_trigger"
+
+// legacyChangeTriggerName is the trigger created by the Laravel migration, replaced on upgrade
+const legacyChangeTriggerName = "task_changes_trigger"
+
+// notifyPayloadLimit keeps notifications under PostgreSQL's 8000 byte pg_notify limit
+const notifyPayloadLimit = 7900
+
+// oldRowsTable keeps the old rows of reference-mode notifications until they are fetched
+const oldRowsTable = "whagons_old_rows"
+
+// tenantTriggerSQL returns the statements installing the generic notify function and the
+// per-table triggers for a payload mode
+func tenantTriggerSQL(tables []string, mode string) []string {
+	var statements []string
+	if mode == "reference" {
+		statements = append(statements, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %[1]s (
+			id BIGSERIAL PRIMARY KEY,
+			table_name TEXT NOT NULL,
+			old_data JSONB NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS idx_%[1]s_created_at ON %[1]s (created_at);`, oldRowsTable),
+			fmt.Sprintf(`
+		CREATE OR REPLACE FUNCTION whagons_notify_changes()
+		RETURNS TRIGGER AS $$
+		DECLARE
+			notification json;
+			old_ref BIGINT;
+		BEGIN
+			-- Reference payload: the row is fetched by id, the old row is kept in a side table
+			IF TG_OP = 'INSERT' THEN
+				notification = json_build_object(
+					'table', TG_TABLE_NAME,
+					'operation', TG_OP,
+					'ref', true,
+					'id', NEW.id,
+					'txid', txid_current(),
+					'timestamp', extract(epoch from now())
+				);
+			ELSE
+				INSERT INTO %[1]s (table_name, old_data) VALUES (TG_TABLE_NAME, to_jsonb(OLD)) RETURNING id INTO old_ref;
+				IF TG_OP = 'UPDATE' THEN
+					notification = json_build_object(
+						'table', TG_TABLE_NAME,
+						'operation', TG_OP,
+						'ref', true,
+						'id', NEW.id,
+						'old_ref', old_ref,
+						'txid', txid_current(),
+						'timestamp', extract(epoch from now())
+					);
+				ELSE
+					notification = json_build_object(
+						'table', TG_TABLE_NAME,
+						'operation', TG_OP,
+						'ref', true,
+						'id', OLD.id,
+						'old_ref', old_ref,
+						'txid', txid_current(),
+						'timestamp', extract(epoch from now())
+					);
+				END IF;
+			END IF;
+
+			PERFORM pg_notify('%[2]s', notification::text);
+
+			IF TG_OP = 'DELETE' THEN
+				RETURN OLD;
+			ELSE
+				RETURN NEW;
+			END IF;
+		END;
+		$$ LANGUAGE plpgsql;`, oldRowsTable, notifyChannelName))
+	} else {
+		statements = append(statements, fmt.Sprintf(`
+		CREATE OR REPLACE FUNCTION whagons_notify_changes()
+		RETURNS TRIGGER AS $$
+		DECLARE
+			notification json;
+		BEGIN
+			-- Build notification payload
+			IF TG_OP = 'DELETE' THEN
+				notification = json_build_object(
+					'table', TG_TABLE_NAME,
+					'operation', TG_OP,
+					'old_data', row_to_json(OLD),
+					'txid', txid_current(),
+					'timestamp', extract(epoch from now())
+				);
+			ELSE
+				notification = json_build_object(
+					'table', TG_TABLE_NAME,
+					'operation', TG_OP,
+					'new_data', row_to_json(NEW),
+					'old_data', CASE WHEN TG_OP = 'UPDATE' THEN row_to_json(OLD) ELSE NULL END,
+					'txid', txid_current(),
+					'timestamp', extract(epoch from now())
+				);
+			END IF;
+
+			-- Fall back to a reference payload when it exceeds the pg_notify limit
+			IF octet_length(notification::text) > %[1]d THEN
+				IF TG_OP = 'DELETE' THEN
+					notification = json_build_object('table', TG_TABLE_NAME, 'operation', TG_OP, 'ref', true,
+						'id', OLD.id, 'truncated', true, 'txid', txid_current(), 'timestamp', extract(epoch from now()));
+				ELSE
+					notification = json_build_object('table', TG_TABLE_NAME, 'operation', TG_OP, 'ref', true,
+						'id', NEW.id, 'truncated', true, 'txid', txid_current(), 'timestamp', extract(epoch from now()));
+				END IF;
+			END IF;
+
+			PERFORM pg_notify('%[2]s', notification::text);
+
+			IF TG_OP = 'DELETE' THEN
+				RETURN OLD;
+			ELSE
+				RETURN NEW;
+			END IF;
+		END;
+		$$ LANGUAGE plpgsql;`, notifyPayloadLimit, notifyChannelName))
+	}
+
+	for _, table := range tables {
+		statements = append(statements,
+			fmt.Sprintf(`DROP TRIGGER IF EXISTS %s ON %s;`, legacyChangeTriggerName, table),
+			fmt.Sprintf(`DROP TRIGGER IF EXISTS %s ON %s;`, changeTriggerName, table),
+			fmt.Sprintf(`CREATE TRIGGER %s
+			AFTER INSERT OR UPDATE OR DELETE ON %s
+			FOR EACH ROW
+			EXECUTE FUNCTION whagons_notify_changes();`, changeTriggerName, table))
+	}
+	return statements
+}
+
+// setupTenantTriggers installs or upgrades the notify triggers of the tracked tables in a tenant
+// database. In dry-run mode the SQL is only logged.
+func (e *RealtimeEngine) setupTenantTriggers(tenant TenantDB, db *sql.DB) error {
+	tables := parseList(config.TrackedTables)
+	if len(tables) == 0 {
+		return nil
+	}
+	for _, table := range tables {
+		if !identifierPattern.MatchString(table) {
+			return fmt.Errorf("invalid tracked table name: %s", table)
+		}
+	}
+
+	if sourceName := changeSourceNameForTenant(tenant.Name); sourceName != "notify" && sourceName != "" {
+		log.Printf("🔍 Skipping notify triggers for tenant %s (change source: %s)", tenant.Name, sourceName)
+		return nil
+	}
+
+	statements := tenantTriggerSQL(tables, config.NotifyPayloadMode)
+
+	if config.TriggerDryRun {
+		log.Printf("📝 [dry-run] Notify triggers for tenant %s (database: %s):\n%s",
+			tenant.Name, tenant.Database, strings.Join(statements, "\n"))
+		return nil
+	}
+
+	log.Printf("🔧 Setting up notify triggers for tenant %s...", tenant.Name)
+
+	// One transaction, so tables are never left without a trigger between drop and create
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to install notify triggers: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("✅ Notify triggers (%s payloads) installed on %d table(s) of tenant %s: %s",
+		config.NotifyPayloadMode, len(tables), tenant.Name, strings.Join(tables, ", "))
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"os"
 	"sync"
 	"time"
 
@@ -24,6 +25,37 @@ type PostgreSQLNotification struct {
 	NewData   json.RawMessage `json:"new_data,omitempty"`
 	OldData   json.RawMessage `json:"old_data,omitempty"`
 	Timestamp float64         `json:"timestamp"`
+	Reference bool            `json:"ref,omitempty"`       // reference payload: rows are fetched by id
+	ID        json.RawMessage `json:"id,omitempty"`        // reference payload: primary key of the row
+	OldRef    *int64          `json:"old_ref,omitempty"`   // reference payload: id of the old row in whagons_old_rows
+	Truncated bool            `json:"truncated,omitempty"` // full payload too large for pg_notify, sent as a reference
+	TxID      json.Number     `json:"txid,omitempty"`      // txid_current() of the writing transaction
+}
+
+// ChangeEvent is a normalized table change emitted by a ChangeSource
+type ChangeEvent struct {
+	TenantName string
+	Source     string
+	Table      string
+	Operation  string
+	NewData    json.RawMessage
+	OldData    json.RawMessage
+	Timestamp  float64
+	TxID       string // transaction the change belongs to (txid or commit LSN), empty when unknown
+	TxEnd      bool   // last change of its transaction, when the source knows it
+}
+
+// ChangeHandler receives the change events emitted by a ChangeSource
+type ChangeHandler func(event ChangeEvent)
+
+// ChangeSource produces change events for a single tenant database
+type ChangeSource interface {
+	// Name returns the configured name of the source (notify, replication, outbox, memory)
+	Name() string
+	// Start prepares the source and streams changes to emit in the background
+	Start(tenant TenantDB, emit ChangeHandler) error
+	// Stop stops streaming; no events are emitted after it returns
+	Stop()
 }
 
 // TaskRecord represents a task record from the wh_tasks table
@@ -49,16 +81,40 @@ type TaskRecord struct {
 
 // PublicationMessage represents a clean publication message for the frontend
 type PublicationMessage struct {
-	Type        string      `json:"type"`
-	TenantName  string      `json:"tenant_name"`
-	Table       string      `json:"table"`
-	Operation   string      `json:"operation"`
-	NewData     *TaskRecord `json:"new_data,omitempty"`
-	OldData     *TaskRecord `json:"old_data,omitempty"`
-	Message     string      `json:"message"`
-	DBTimestamp float64     `json:"db_timestamp"`
-	ClientTime  string      `json:"client_timestamp"`
-	SessionId   string      `json:"sessionId"`
+	Type           string      `json:"type"`
+	TenantName     string      `json:"tenant_name"`
+	Table          string      `json:"table"`
+	Operation      string      `json:"operation"`
+	NewData        interface{} `json:"new_data,omitempty"` // Typed record from a RowDecoder or map[string]interface{}
+	OldData        interface{} `json:"old_data,omitempty"` // Typed record from a RowDecoder or map[string]interface{}
+	Message        string      `json:"message"`
+	DBTimestamp    float64     `json:"db_timestamp"`
+	ClientTime     string      `json:"client_timestamp"`
+	Sequence       uint64      `json:"seq,omitempty"`             // Per-tenant, monotonically increasing change number
+	TxID           string      `json:"txid,omitempty"`            // Database transaction of the change
+	Encoding       string      `json:"encoding,omitempty"`        // "delta": new_data only holds the key and changed columns
+	ChangedColumns []string    `json:"changed_columns,omitempty"` // delta encoding: columns present in new_data besides the key
+	SessionId      string      `json:"sessionId"`
+}
+
+// BatchMessage delivers the changes of one database transaction together
+type BatchMessage struct {
+	Type       string            `json:"type"` // "batch"
+	TenantName string            `json:"tenant_name"`
+	TxID       string            `json:"txid"`
+	Changes    []json.RawMessage `json:"changes"` // PublicationMessages, marshaled once per broadcast
+	FirstSeq   uint64            `json:"first_seq"`
+	LastSeq    uint64            `json:"last_seq"`
+	ClientTime string            `json:"client_timestamp"`
+	SessionId  string            `json:"sessionId"`
+}
+
+// RowDecoder converts a raw row payload into a typed record for a specific table
+type RowDecoder func(raw json.RawMessage) (interface{}, error)
+
+// RowAuthorizer decides whether a user of a tenant may see a row of the table it is registered for
+type RowAuthorizer interface {
+	CanSeeRow(tenantName string, userID int, row map[string]interface{}) (bool, error)
 }
 
 // SystemMessage represents system messages (connection, echo, etc.)
@@ -71,6 +127,115 @@ type SystemMessage struct {
 	SessionId string      `json:"sessionId"`
 }
 
+// ClientCommand represents a JSON command sent by a client over SockJS
+type ClientCommand struct {
+	Command   string                 `json:"command"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Tables    []string               `json:"tables,omitempty"`
+	Filter    map[string]interface{} `json:"filter,omitempty"`     // column -> value (equality) or array of values (IN)
+	Since     *uint64                `json:"since,omitempty"`      // resume: last sequence number the client applied
+	Epoch     string                 `json:"epoch,omitempty"`      // resume: stream epoch the sequence belongs to
+	Table     string                 `json:"table,omitempty"`      // snapshot: table to read
+	PageSize  int                    `json:"page_size,omitempty"`  // snapshot: rows per chunk
+	After     string                 `json:"after,omitempty"`      // snapshot: continue after this key
+	Window    int                    `json:"window,omitempty"`     // checksum: number of most recently updated rows
+	Full      bool                   `json:"full,omitempty"`       // checksum: hash the whole table instead of a window
+	RangeSize int                    `json:"range_size,omitempty"` // checksum: also return one checksum per key range of this size
+	Encoding  string                 `json:"encoding,omitempty"`   // subscribe: full | delta message encoding for UPDATEs
+	Token     string                 `json:"token,omitempty"`      // auth: bearer token
+	Domain    string                 `json:"domain,omitempty"`     // auth: tenant domain (defaults to the one of the connection)
+	IDs       []string               `json:"ids,omitempty"`        // ack_notifications: notifications to mark as read
+	All       bool                   `json:"all,omitempty"`        // ack_notifications: mark every unread notification as read
+	Limit     int                    `json:"limit,omitempty"`      // list_notifications: maximum number of notifications
+}
+
+// Notification is a Laravel database notification of a user
+type Notification struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	ReadAt    *time.Time      `json:"read_at"`
+	CreatedAt *time.Time      `json:"created_at"`
+}
+
+// TableChecksum is the result of a checksum over a tenant table or its most recently updated rows
+type TableChecksum struct {
+	Type           string               `json:"type"`
+	TenantName     string               `json:"tenant_name"`
+	Table          string               `json:"table"`
+	Window         int                  `json:"window"` // 0 = full table
+	Columns        []string             `json:"columns"`
+	Source         string               `json:"source"` // row_hashes | table
+	Checksum       string               `json:"checksum"`
+	RowCount       int                  `json:"row_count"`
+	RangeSize      int                  `json:"range_size,omitempty"`
+	Ranges         []ChecksumRange      `json:"ranges,omitempty"`
+	LastRowID      *string              `json:"last_row_id"`
+	LastUpdated    *string              `json:"last_updated"`
+	Epoch          string               `json:"epoch"`
+	Sequence       uint64               `json:"seq"`
+	LSN            string               `json:"lsn,omitempty"`
+	ChangesSince   []PublicationMessage `json:"changes_since,omitempty"`
+	ResyncRequired bool                 `json:"resync_required,omitempty"`
+	RequestID      string               `json:"request_id,omitempty"`
+	Timestamp      string               `json:"timestamp"`
+}
+
+// ChecksumRange is the checksum of the rows whose id falls in [From, To]
+type ChecksumRange struct {
+	From     int64  `json:"from"`
+	To       int64  `json:"to"`
+	Checksum string `json:"checksum"`
+	RowCount int    `json:"row_count"`
+}
+
+// SnapshotMessage carries the progress and row chunks of a table snapshot
+type SnapshotMessage struct {
+	Type       string        `json:"type"`
+	Operation  string        `json:"operation"` // started | chunk | complete | error
+	RequestID  string        `json:"request_id,omitempty"`
+	TenantName string        `json:"tenant_name"`
+	Table      string        `json:"table"`
+	Rows       []interface{} `json:"rows,omitempty"`
+	Page       int           `json:"page,omitempty"`
+	Cursor     string        `json:"cursor,omitempty"` // Key of the last row sent, usable as "after"
+	Epoch      string        `json:"epoch"`            // Stream epoch the snapshot position belongs to
+	Sequence   uint64        `json:"seq"`              // Apply live changes with a higher seq after the snapshot
+	LSN        string        `json:"lsn,omitempty"`    // WAL position of the snapshot
+	TotalRows  int           `json:"total_rows,omitempty"`
+	Message    string        `json:"message,omitempty"`
+	Timestamp  string        `json:"timestamp"`
+	SessionId  string        `json:"sessionId"`
+}
+
+// SessionSubscriptions holds the tables a session subscribed to
+type SessionSubscriptions struct {
+	Tables map[string]*TableSubscription // table name (or "*") -> subscription
+	mutex  sync.RWMutex
+}
+
+// TableSubscription represents a session's subscription to a single table
+type TableSubscription struct {
+	Table        string                     `json:"table"`
+	Filter       map[string]interface{}     `json:"filter,omitempty"`
+	Encoding     string                     `json:"encoding,omitempty"` // full | delta, empty = server default
+	SubscribedAt time.Time                  `json:"subscribed_at"`
+	allowed      map[string]map[string]bool // column -> normalized allowed values
+}
+
+// TenantStream numbers a tenant's changes and keeps the most recent ones for resuming clients
+type TenantStream struct {
+	TenantName string
+	Epoch      string               // Changes whenever sequence numbers restart
+	sequence   uint64               // Last assigned sequence number
+	buffer     []PublicationMessage // Ring buffer of the most recent changes
+	next       int                  // Ring position of the next write
+	count      int                  // Number of buffered changes
+	logFile    *os.File             // Optional on-disk replay log
+	logLines   int
+	mutex      sync.Mutex
+}
+
 // RealtimeEngine is the main engine that manages database connections and WebSocket sessions
 type RealtimeEngine struct {
 	landlordDB            *sql.DB
@@ -79,18 +244,44 @@ type RealtimeEngine struct {
 	negotiationSessions   map[string]sockjs.Session        // Sessions in negotiation phase
 	authenticatedSessions map[string]*AuthenticatedSession // sessionID -> auth info
 	tokenCache            map[string]*CachedToken          // tokenHash -> cached auth info
+	rowDecoders           map[string]RowDecoder            // table name -> typed row decoder
+	rowAuthorizers        map[string]RowAuthorizer         // table name -> row-level authorization
+	tenantListeners       map[string]*tenantListener       // tenant name -> LISTEN connection of the engine channels
+	authenticators        map[string]Authenticator         // authenticator name -> implementation
+	changeSources         map[string]ChangeSource          // tenant name -> running change source
+	subscriptions         map[string]*SessionSubscriptions // sessionID -> subscribed tables (absent = all tables)
+	streams               map[string]*TenantStream         // tenant name -> sequence counter and replay buffer
+	rowHashTables         map[string]map[string]bool       // tenant name -> tables with maintained row_hashes
+	batchers              map[string]*changeBatcher        // tenant name -> transaction batcher in front of processChanges
+	outboundQueues        map[string]*outboundQueue        // sessionID -> bounded send queue
+	outboundStats         outboundStats
 	mutex                 sync.RWMutex
+
+	// Active sessions indexed per tenant and user for fan-out, locked apart from the maps above
+	tenantSessions      map[string]*tenantSessions // tenant name -> session index
+	tenantSessionsMutex sync.RWMutex
+}
+
+// Authenticator validates bearer tokens for a tenant
+type Authenticator interface {
+	// Name returns the configured name of the authenticator (sanctum, jwt)
+	Name() string
+	// Authenticate validates a bearer token against a tenant and its database
+	Authenticate(tenant TenantDB, db *sql.DB, bearerToken string) (*AuthenticatedSession, error)
 }
 
 // AuthenticatedSession represents an authenticated WebSocket session
 type AuthenticatedSession struct {
-	SessionID  string
-	TenantName string
-	UserID     int
-	TokenID    int
-	Abilities  []string
-	ExpiresAt  *time.Time
-	LastUsedAt time.Time
+	SessionID     string
+	TenantName    string
+	UserID        int
+	TokenID       int    // Sanctum token id, 0 for other authenticators
+	TokenKey      string // SHA-256 of the bearer token, shared by every session of the token
+	Authenticator string // name of the authenticator that accepted the token
+	Domain        string // domain the session authenticated for
+	Abilities     []string
+	ExpiresAt     *time.Time
+	LastUsedAt    time.Time
 }
 
 // PersonalAccessToken represents a Laravel Sanctum token from the database
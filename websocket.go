@@ -1,8 +1,8 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"log"
 	"time"
 
@@ -27,96 +27,72 @@ func (e *RealtimeEngine) sockjsHandler(session sockjs.Session) {
 	)
 	domain := request.URL.Query().Get("domain")
 
-	if token == "" {
-		log.Printf("❌ No bearer token provided for session %s", session.ID())
-		e.sendAuthError(session, "Bearer token required")
-		session.Close(4001, "Authentication required")
-		return
-	}
-
-	if domain == "" {
-		log.Printf("❌ No domain provided for session %s", session.ID())
-		e.sendAuthError(session, "Domain parameter required")
-		session.Close(4001, "Domain required")
-		return
-	}
+	var authSession *AuthenticatedSession
+	requestID := ""
+	inBand := token == ""
+	if inBand {
+		// No token in the URL (where it would end up in access logs): wait for an auth command
+		authSession, requestID = e.awaitAuthentication(session, domain)
+		if authSession == nil {
+			return
+		}
+	} else {
+		if domain == "" {
+			log.Printf("❌ No domain provided for session %s", session.ID())
+			e.sendAuthError(session, "Domain parameter required")
+			session.Close(4001, "Domain required")
+			return
+		}
 
-	// Authenticate the token for the specific domain
-	authSession, err := e.authenticateTokenForDomain(token, domain)
-	if err != nil {
-		log.Printf("❌ Authentication failed for session %s (domain: %s): %v", session.ID(), domain, err)
-		e.sendAuthError(session, fmt.Sprintf("Authentication failed for domain %s", domain))
-		session.Close(4001, "Authentication failed")
-		return
+		// Authenticate the token for the specific domain
+		var failure *authFailure
+		authSession, failure = e.authenticateConnection(session.ID(), token, domain)
+		if failure != nil {
+			e.sendAuthError(session, failure.message)
+			session.Close(failure.code, failure.reason)
+			return
+		}
 	}
 
-	// Set the session ID in the auth session
-	authSession.SessionID = session.ID()
-
-	// DON'T add to session tracking yet - wait until we receive the first real message
-	// This prevents counting SockJS negotiation sessions that will be discarded
-	log.Printf("✅ Authenticated negotiation session %s for domain: %s, tenant: %s, user: %d (not yet active)",
-		session.ID(), domain, authSession.TenantName, authSession.UserID)
+	log.Printf("✅ Authenticated negotiation session %s for domain: %s, tenant: %s, user: %d (in-band: %t)",
+		session.ID(), authSession.Domain, authSession.TenantName, authSession.UserID, inBand)
 
-	// Send welcome message with tenant info
-	welcomeMsg := SystemMessage{
-		Type:      "system",
-		Operation: "authenticated",
-		Message:   fmt.Sprintf("Authenticated for domain: %s (tenant: %s)", domain, authSession.TenantName),
-		Data: map[string]interface{}{
-			"domain":      domain,
-			"tenant_name": authSession.TenantName,
-			"user_id":     authSession.UserID,
-			"abilities":   authSession.Abilities,
-		},
-		Timestamp: time.Now().Format(time.RFC3339),
-		SessionId: session.ID(),
-	}
-	if welcomeJSON, err := json.Marshal(welcomeMsg); err == nil {
-		if sendErr := session.Send(string(welcomeJSON)); sendErr != nil {
-			log.Printf("💀 Negotiation session %s failed to send welcome - connection dead", session.ID())
-			return
+	// Send welcome message with tenant info and the stream position to resume from
+	if err := e.sendWelcome(session, authSession, "authenticated", requestID); err != nil {
+		log.Printf("💀 Negotiation session %s failed to send welcome - connection dead", session.ID())
+		if inBand {
+			e.mutex.Lock()
+			e.forgetSessionLocked(session.ID())
+			e.mutex.Unlock()
 		}
-		log.Printf("📤 Sent welcome message to negotiation session %s", session.ID())
+		return
 	}
+	log.Printf("📤 Sent welcome message to negotiation session %s", session.ID())
 
-	// Add this session to negotiation tracking - don't count toward active sessions yet
 	e.mutex.Lock()
-	e.negotiationSessions[session.ID()] = session
 	e.authenticatedSessions[session.ID()] = authSession
+	if inBand {
+		// The auth command was real communication, so the session is active right away
+		delete(e.negotiationSessions, session.ID())
+		e.sessions[session.ID()] = session
+		e.indexSessionLocked(session, authSession)
+	} else {
+		// DON'T count toward active sessions until the first real message arrives
+		// This prevents counting SockJS negotiation sessions that will be discarded
+		e.negotiationSessions[session.ID()] = session
+	}
 	activeSessionCount := len(e.sessions)
 	negotiationCount := len(e.negotiationSessions)
 	e.mutex.Unlock()
 
-	log.Printf("🎯 Session %s added to NEGOTIATION (active: %d, negotiating: %d) - waiting for real communication",
-		session.ID(), activeSessionCount, negotiationCount)
-
-	// Set a timeout to close unused negotiation sessions
-	negotiationTimeout := time.NewTimer(15 * time.Second)
-	sessionClosed := make(chan bool, 1)
-
-	// Goroutine to handle negotiation timeout
-	go func() {
-		select {
-		case <-negotiationTimeout.C:
-			// Timeout reached - close this negotiation session if it's still unused
-			e.mutex.Lock()
-			if _, stillNegotiating := e.negotiationSessions[session.ID()]; stillNegotiating {
-				delete(e.negotiationSessions, session.ID())
-				delete(e.authenticatedSessions, session.ID())
-				e.mutex.Unlock()
-
-				log.Printf("⏰ Negotiation timeout - closing unused session %s", session.ID())
-				session.Close(4001, "Negotiation timeout - session unused")
-				sessionClosed <- true
-			} else {
-				e.mutex.Unlock()
-			}
-		case <-sessionClosed:
-			// Session was promoted or closed elsewhere, stop timeout
-			negotiationTimeout.Stop()
-		}
-	}()
+	if inBand {
+		log.Printf("🔥 Session %s authenticated in-band and ACTIVE (active: %d, negotiating: %d)",
+			session.ID(), activeSessionCount, negotiationCount)
+	} else {
+		log.Printf("🎯 Session %s added to NEGOTIATION (active: %d, negotiating: %d) - waiting for real communication",
+			session.ID(), activeSessionCount, negotiationCount)
+		e.expireNegotiation(session)
+	}
 
 	// Handle incoming messages - promote to active session on first real message
 	for {
@@ -127,6 +103,7 @@ func (e *RealtimeEngine) sockjsHandler(session sockjs.Session) {
 			if _, exists := e.negotiationSessions[session.ID()]; exists {
 				delete(e.negotiationSessions, session.ID())
 				e.sessions[session.ID()] = session
+				e.indexSessionLocked(session, authSession)
 				activeCount := len(e.sessions)
 				negotiationCount := len(e.negotiationSessions)
 				e.mutex.Unlock()
@@ -137,26 +114,19 @@ func (e *RealtimeEngine) sockjsHandler(session sockjs.Session) {
 				e.mutex.Unlock()
 			}
 
-			log.Printf("📥 SockJS received: '%s' from active session %s (tenant: %s)",
-				msg, session.ID(), authSession.TenantName)
-
-			// Echo the message back
-			response := SystemMessage{
-				Type:      "echo",
-				Operation: "echo",
-				Message:   fmt.Sprintf("Echo from %s: %s", authSession.TenantName, msg),
-				Data:      msg,
-				Timestamp: time.Now().Format(time.RFC3339),
-				SessionId: session.ID(),
+			logged := msg
+			if isAuthCommand(msg) {
+				// Never log bearer tokens
+				logged = `{"command":"auth"}`
 			}
+			log.Printf("📥 SockJS received: '%s' from active session %s (tenant: %s)",
+				logged, session.ID(), authSession.TenantName)
 
-			if responseJSON, err := json.Marshal(response); err == nil {
-				if sendErr := session.Send(string(responseJSON)); sendErr != nil {
-					log.Printf("❌ SockJS send error: %v", sendErr)
-					break
-				}
-				log.Printf("📤 SockJS sent echo to active session %s", session.ID())
+			if err := e.handleClientMessage(session, authSession, msg); err != nil {
+				break
 			}
+			// The auth command may have rotated the session's token
+			authSession = e.currentSessionAuth(session.ID(), authSession)
 		} else {
 			log.Printf("❌ SockJS receive error from session %s: %v", session.ID(), err)
 			break
@@ -167,6 +137,36 @@ func (e *RealtimeEngine) sockjsHandler(session sockjs.Session) {
 	e.cleanupSession(session.ID(), authSession.TenantName)
 }
 
+// expireNegotiation closes a negotiation session that stays unused for 15 seconds
+func (e *RealtimeEngine) expireNegotiation(session sockjs.Session) {
+	// Set a timeout to close unused negotiation sessions
+	negotiationTimeout := time.NewTimer(15 * time.Second)
+	sessionClosed := make(chan bool, 1)
+
+	// Goroutine to handle negotiation timeout
+	go func() {
+		select {
+		case <-negotiationTimeout.C:
+			// Timeout reached - close this negotiation session if it's still unused
+			e.mutex.Lock()
+			if _, stillNegotiating := e.negotiationSessions[session.ID()]; stillNegotiating {
+				delete(e.negotiationSessions, session.ID())
+				delete(e.authenticatedSessions, session.ID())
+				e.mutex.Unlock()
+
+				log.Printf("⏰ Negotiation timeout - closing unused session %s", session.ID())
+				session.Close(4001, "Negotiation timeout - session unused")
+				sessionClosed <- true
+			} else {
+				e.mutex.Unlock()
+			}
+		case <-sessionClosed:
+			// Session was promoted or closed elsewhere, stop timeout
+			negotiationTimeout.Stop()
+		}
+	}()
+}
+
 // sendAuthError sends an authentication error message
 func (e *RealtimeEngine) sendAuthError(session sockjs.Session, message string) {
 	errorMsg := SystemMessage{
@@ -184,38 +184,31 @@ func (e *RealtimeEngine) sendAuthError(session sockjs.Session, message string) {
 // broadcastSystemMessage sends a system message to all connected sessions
 func (e *RealtimeEngine) BroadcastSystemMessage(message SystemMessage) {
 	e.mutex.RLock()
-	// Only broadcast to ACTIVE sessions, not negotiation sessions
-	sessions := make(map[string]sockjs.Session)
-	for id, session := range e.sessions {
-		sessions[id] = session
+	// Only broadcast to ACTIVE sessions (those with a send queue), not negotiation sessions
+	queues := make([]*outboundQueue, 0, len(e.outboundQueues))
+	for _, q := range e.outboundQueues {
+		queues = append(queues, q)
 	}
 	e.mutex.RUnlock()
 
-	broadcastCount := 0
-	for sessionID, session := range sessions {
-		// Set the sessionId for this specific session
-		message.SessionId = sessionID
-
-		jsonMessage, err := json.Marshal(message)
-		if err != nil {
-			log.Printf("❌ Failed to marshal system message: %v", err)
-			continue
-		}
+	// Marshal once, the sessionId is added for each session
+	message.SessionId = ""
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("❌ Failed to marshal system message: %v", err)
+		return
+	}
 
-		if err := session.Send(string(jsonMessage)); err != nil {
-			log.Printf("❌ Failed to send to active session %s: %v", sessionID, err)
-			// Remove failed session
-			e.mutex.Lock()
-			delete(e.sessions, sessionID)
-			delete(e.authenticatedSessions, sessionID)
-			e.mutex.Unlock()
-		} else {
+	// Each session's writer sends it after the publications queued before it
+	broadcastCount := 0
+	for _, q := range queues {
+		if q.enqueueMessage(payload) {
 			broadcastCount++
 		}
 	}
 
 	if broadcastCount > 0 {
-		log.Printf("📡 Broadcasted system message to %d ACTIVE sessions", broadcastCount)
+		log.Printf("📡 Queued system message for %d ACTIVE sessions", broadcastCount)
 	}
 }
 
@@ -240,15 +233,19 @@ func (e *RealtimeEngine) GetTotalSessionsCount() int {
 	return len(e.sessions) + len(e.negotiationSessions)
 }
 
+// disconnectDrainTimeout bounds how long disconnecting waits for active sessions to receive what
+// was queued for them before the disconnect message
+const disconnectDrainTimeout = 5 * time.Second
+
 // disconnectAllSessions gracefully disconnects all active sessions
 func (e *RealtimeEngine) DisconnectAllSessions() {
 	e.mutex.Lock()
-	activeSessions := make(map[string]sockjs.Session)
+	activeSessions := make(map[string]*outboundQueue)
 	negotiationSessions := make(map[string]sockjs.Session)
 
-	// Copy both active and negotiation sessions
-	for id, session := range e.sessions {
-		activeSessions[id] = session
+	// Copy both active (through their send queues) and negotiation sessions
+	for id, q := range e.outboundQueues {
+		activeSessions[id] = q
 	}
 	for id, session := range e.negotiationSessions {
 		negotiationSessions[id] = session
@@ -263,13 +260,21 @@ func (e *RealtimeEngine) DisconnectAllSessions() {
 		Timestamp: time.Now().Format(time.RFC3339),
 	}
 
-	// Disconnect active sessions
-	for sessionID, session := range activeSessions {
-		disconnectMsg.SessionId = sessionID
-		if msgJSON, err := json.Marshal(disconnectMsg); err == nil {
-			session.Send(string(msgJSON))
+	// Disconnect active sessions: their writers send the message last and close them
+	disconnectJSON, _ := json.Marshal(disconnectMsg)
+	for _, q := range activeSessions {
+		q.enqueueClose(disconnectJSON, 1000, "Server shutdown")
+	}
+	drained, cancel := context.WithTimeout(context.Background(), disconnectDrainTimeout)
+	defer cancel()
+	for sessionID, q := range activeSessions {
+		select {
+		case <-q.done:
+		case <-drained.Done():
+			// Too slow to drain, close it without the rest of its queue
+			q.close()
+			q.session.Close(1000, "Server shutdown")
 		}
-		session.Close(1000, "Server shutdown")
 		log.Printf("📡 Disconnected ACTIVE session: %s", sessionID)
 	}
 
@@ -284,8 +289,16 @@ func (e *RealtimeEngine) DisconnectAllSessions() {
 	e.sessions = make(map[string]sockjs.Session)
 	e.negotiationSessions = make(map[string]sockjs.Session)
 	e.authenticatedSessions = make(map[string]*AuthenticatedSession)
+	e.subscriptions = make(map[string]*SessionSubscriptions)
+	for sessionID := range e.outboundQueues {
+		e.removeOutboundQueueLocked(sessionID)
+	}
 	e.mutex.Unlock()
 
+	e.tenantSessionsMutex.Lock()
+	e.tenantSessions = make(map[string]*tenantSessions)
+	e.tenantSessionsMutex.Unlock()
+
 	totalDisconnected := len(activeSessions) + len(negotiationSessions)
 	log.Printf("📡 All sessions disconnected - %d active, %d negotiation, %d total",
 		len(activeSessions), len(negotiationSessions), totalDisconnected)
@@ -343,9 +356,7 @@ func (e *RealtimeEngine) GetCacheStats() map[string]int {
 func (e *RealtimeEngine) cleanupSession(sessionID, tenantName string) {
 	e.mutex.Lock()
 	// Remove from both active and negotiation sessions
-	delete(e.sessions, sessionID)
-	delete(e.negotiationSessions, sessionID)
-	delete(e.authenticatedSessions, sessionID)
+	e.forgetSessionLocked(sessionID)
 	remainingActive := len(e.sessions)
 	remainingNegotiation := len(e.negotiationSessions)
 	e.mutex.Unlock()
@@ -391,15 +402,13 @@ func (e *RealtimeEngine) cleanupZombieSessions() {
 
 	// Clean up zombie active sessions
 	for _, sessionID := range zombieActiveSessions {
-		delete(e.sessions, sessionID)
-		delete(e.authenticatedSessions, sessionID)
+		e.forgetSessionLocked(sessionID)
 		log.Printf("🧹 Cleaned up zombie ACTIVE session: %s", sessionID)
 	}
 
 	// Clean up zombie negotiation sessions
 	for _, sessionID := range zombieNegotiationSessions {
-		delete(e.negotiationSessions, sessionID)
-		delete(e.authenticatedSessions, sessionID)
+		e.forgetSessionLocked(sessionID)
 		log.Printf("🧹 Cleaned up zombie NEGOTIATION session: %s", sessionID)
 	}
 